@@ -0,0 +1,180 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  bus.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrBusClosed is returned by I2CBus implementations when reading from
+// or writing to a bus which has not been opened.
+var ErrBusClosed = errors.New("i2c bus is not open")
+
+// I2CBus is the transport a Fan uses to talk to the controller of the ArgonOne case.
+//
+// The default implementation is DevI2CBus, which uses the i2c character devices
+// of the Linux kernel. MemoryI2CBus can be used to drive a Fan without any hardware,
+// for example in tests.
+type I2CBus interface {
+	// Open opens the given bus and selects the device at address
+	// for subsequent reads and writes.
+	Open(bus, address int) error
+	io.ReadWriteCloser
+}
+
+// WithI2CBus is an option that sets the transport used to talk to the fan.
+// Defaults to a DevI2CBus.
+func WithI2CBus(b I2CBus) FanOption {
+	return func(f *Fan) error {
+		if b == nil {
+			return errors.New("i2c bus must not be nil")
+		}
+		f.i2c = b
+		return nil
+	}
+}
+
+// MemoryI2CBus is an in-memory I2CBus.
+// It records everything written to it and serves reads from data
+// previously queued with QueueRead.
+type MemoryI2CBus struct {
+	mu      sync.Mutex
+	open    bool
+	bus     int
+	address int
+	writes  [][]byte
+	reads   []byte
+	failErr error
+	err     error
+}
+
+// NewMemoryI2CBus creates a new MemoryI2CBus.
+func NewMemoryI2CBus() *MemoryI2CBus {
+	return &MemoryI2CBus{}
+}
+
+// Open marks the bus as open for the given bus number and address.
+func (m *MemoryI2CBus) Open(bus, address int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.takeFailure(); err != nil {
+		return err
+	}
+	m.open, m.bus, m.address = true, bus, address
+	return nil
+}
+
+// Write records p.
+func (m *MemoryI2CBus) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.open {
+		return 0, ErrBusClosed
+	}
+	if err := m.takeFailure(); err != nil {
+		return 0, err
+	}
+	m.writes = append(m.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// Read fills p from the data queued with QueueRead.
+// It returns io.ErrUnexpectedEOF if not enough data is queued.
+func (m *MemoryI2CBus) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.open {
+		return 0, ErrBusClosed
+	}
+	if err := m.takeFailure(); err != nil {
+		return 0, err
+	}
+	if len(m.reads) < len(p) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, m.reads)
+	m.reads = m.reads[n:]
+	return n, nil
+}
+
+// Close marks the bus as closed.
+func (m *MemoryI2CBus) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.open = false
+	return nil
+}
+
+// IsOpen reports whether the bus is currently open.
+func (m *MemoryI2CBus) IsOpen() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.open
+}
+
+// Device returns the bus number and address the bus was last opened with.
+func (m *MemoryI2CBus) Device() (bus, address int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bus, m.address
+}
+
+// Writes returns a copy of everything written to the bus so far,
+// one entry per call to Write.
+func (m *MemoryI2CBus) Writes() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := make([][]byte, len(m.writes))
+	copy(w, m.writes)
+	return w
+}
+
+// QueueRead appends p to the data served by Read.
+func (m *MemoryI2CBus) QueueRead(p ...byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads = append(m.reads, p...)
+}
+
+// FailNext makes the next call to Open, Read or Write return err.
+func (m *MemoryI2CBus) FailNext(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failErr = err
+}
+
+// SetError makes all calls to Open, Read or Write return err
+// until SetError is called with nil.
+func (m *MemoryI2CBus) SetError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func (m *MemoryI2CBus) takeFailure() error {
+	if m.err != nil {
+		return m.err
+	}
+	err := m.failErr
+	m.failErr = nil
+	return err
+}
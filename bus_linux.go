@@ -0,0 +1,87 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  bus_linux.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// i2cSlave is the ioctl request selecting the device address
+// on an i2c character device, see linux/i2c-dev.h.
+const i2cSlave = 0x0703
+
+// DevI2CBus is an I2CBus using the i2c character devices /dev/i2c-N
+// provided by the i2c-dev kernel module.
+type DevI2CBus struct {
+	f *os.File
+}
+
+// NewDevI2CBus creates a new DevI2CBus.
+func NewDevI2CBus() *DevI2CBus {
+	return &DevI2CBus{}
+}
+
+// Open opens /dev/i2c-<bus> and selects the device at address.
+func (d *DevI2CBus) Open(bus, address int) error {
+	if d.f != nil {
+		d.Close()
+	}
+
+	f, err := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", bus), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("opening i2c bus %d: %w", bus, err)
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(address)); errno != 0 {
+		f.Close()
+		return fmt.Errorf("selecting device 0x%02x on i2c bus %d: %w", address, bus, errno)
+	}
+
+	d.f = f
+	return nil
+}
+
+// Write writes p to the selected device.
+func (d *DevI2CBus) Write(p []byte) (int, error) {
+	if d.f == nil {
+		return 0, ErrBusClosed
+	}
+	return d.f.Write(p)
+}
+
+// Read reads len(p) bytes from the selected device.
+func (d *DevI2CBus) Read(p []byte) (int, error) {
+	if d.f == nil {
+		return 0, ErrBusClosed
+	}
+	return d.f.Read(p)
+}
+
+// Close closes the underlying character device.
+func (d *DevI2CBus) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  bus_other.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import "errors"
+
+var errNoI2CDev = errors.New("i2c character devices are only supported on Linux")
+
+// DevI2CBus is an I2CBus using the i2c character devices /dev/i2c-N.
+// It is only functional on Linux.
+type DevI2CBus struct{}
+
+// NewDevI2CBus creates a new DevI2CBus.
+func NewDevI2CBus() *DevI2CBus {
+	return &DevI2CBus{}
+}
+
+// Open always fails on this platform.
+func (d *DevI2CBus) Open(bus, address int) error { return errNoI2CDev }
+
+// Write always fails on this platform.
+func (d *DevI2CBus) Write(p []byte) (int, error) { return 0, errNoI2CDev }
+
+// Read always fails on this platform.
+func (d *DevI2CBus) Read(p []byte) (int, error) { return 0, errNoI2CDev }
+
+// Close is a no-op on this platform.
+func (d *DevI2CBus) Close() error { return nil }
@@ -0,0 +1,212 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  button.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultButtonChip is the gpio character device the power button
+	// of the ArgonOne case is connected to.
+	DefaultButtonChip = "/dev/gpiochip0"
+	// DefaultButtonLine is the line (BCM pin) of the gpio chip
+	// the controller of the ArgonOne case signals button presses on.
+	DefaultButtonLine = 4
+)
+
+// The controller signals button presses with a pulse on the gpio line.
+// A pulse of 20-30ms is sent for a double tap, a pulse of 40-50ms for
+// a long press. The bounds below leave some room for jitter.
+const (
+	minDoubleTapPulse = 15 * time.Millisecond
+	minLongPressPulse = 35 * time.Millisecond
+	maxLongPressPulse = 70 * time.Millisecond
+)
+
+// ButtonEvent is an event signalled by the power button of the ArgonOne case.
+type ButtonEvent int
+
+const (
+	// ButtonDoubleTap is signalled when the button is tapped twice.
+	// The vendor software reboots the Pi on this event.
+	ButtonDoubleTap ButtonEvent = iota + 1
+	// ButtonLongPress is signalled when the button is held for three seconds.
+	// The vendor software shuts down the Pi on this event.
+	ButtonLongPress
+)
+
+// String returns the name of the event.
+func (e ButtonEvent) String() string {
+	switch e {
+	case ButtonDoubleTap:
+		return "double-tap"
+	case ButtonLongPress:
+		return "long-press"
+	}
+	return fmt.Sprintf("ButtonEvent(%d)", int(e))
+}
+
+// Edge is a level change on a gpio line.
+type Edge struct {
+	// Rising is true for a change from low to high.
+	Rising bool
+	// Timestamp is the time of the change. Only the difference
+	// between the timestamps of two edges is evaluated.
+	Timestamp time.Duration
+}
+
+// EdgeSource delivers the level changes of a gpio line.
+type EdgeSource interface {
+	// ReadEdge blocks until the next level change occurs.
+	// It must return an error after Close was called.
+	ReadEdge() (Edge, error)
+	Close() error
+}
+
+// PowerButtonOption is a function that configures a PowerButton instance.
+type PowerButtonOption func(*PowerButton) error
+
+// PowerButton watches the power button of the ArgonOne case.
+type PowerButton struct {
+	chip   string
+	line   int
+	source EdgeSource
+}
+
+// NewPowerButton creates a new PowerButton watching line 4 of /dev/gpiochip0.
+// Those values can be overridden using the WithGPIOChip and WithGPIOLine options.
+func NewPowerButton(opts ...PowerButtonOption) (*PowerButton, error) {
+	b := &PowerButton{
+		chip: DefaultButtonChip,
+		line: DefaultButtonLine,
+	}
+
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, fmt.Errorf("creating power button: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// WithGPIOChip is an option that sets the gpio character device
+// the power button is connected to.
+// On a Raspberry Pi 5 with older kernels, this is /dev/gpiochip4.
+func WithGPIOChip(path string) PowerButtonOption {
+	return func(b *PowerButton) error {
+		b.chip = path
+		return nil
+	}
+}
+
+// WithGPIOLine is an option that sets the line of the gpio chip
+// the power button is connected to.
+func WithGPIOLine(line int) PowerButtonOption {
+	return func(b *PowerButton) error {
+		if line < 0 {
+			return fmt.Errorf("invalid gpio line: %d", line)
+		}
+		b.line = line
+		return nil
+	}
+}
+
+// WithEdgeSource is an option that sets the source of level changes,
+// replacing the gpio character device.
+func WithEdgeSource(src EdgeSource) PowerButtonOption {
+	return func(b *PowerButton) error {
+		if src == nil {
+			return errors.New("edge source must not be nil")
+		}
+		b.source = src
+		return nil
+	}
+}
+
+// Listen starts watching the power button.
+// Button presses are classified and delivered on the returned channel,
+// which is closed when ctx is cancelled or the gpio line can not be read any more.
+func (b *PowerButton) Listen(ctx context.Context) (<-chan ButtonEvent, error) {
+	src := b.source
+	if src == nil {
+		var err error
+		if src, err = openGPIOLine(b.chip, b.line); err != nil {
+			return nil, fmt.Errorf("opening gpio line %d on %s: %w", b.line, b.chip, err)
+		}
+	}
+
+	events := make(chan ButtonEvent)
+
+	go func() {
+		<-ctx.Done()
+		src.Close()
+	}()
+
+	go func() {
+		defer close(events)
+
+		var rise *Edge
+		for {
+			edge, err := src.ReadEdge()
+			if err != nil {
+				return
+			}
+
+			if edge.Rising {
+				rise = &edge
+				continue
+			}
+			if rise == nil {
+				continue
+			}
+
+			ev, ok := classifyPulse(edge.Timestamp - rise.Timestamp)
+			rise = nil
+			if !ok {
+				continue
+			}
+
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func classifyPulse(width time.Duration) (ButtonEvent, bool) {
+	switch {
+	case width < minDoubleTapPulse:
+		return 0, false
+	case width < minLongPressPulse:
+		return ButtonDoubleTap, true
+	case width <= maxLongPressPulse:
+		return ButtonLongPress, true
+	}
+	return 0, false
+}
@@ -0,0 +1,122 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  button_linux.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The structures and constants below mirror version 2 of the
+// gpio character device uAPI, see linux/gpio.h.
+
+const (
+	gpioV2LineFlagInput        = 1 << 2
+	gpioV2LineFlagEdgeRising   = 1 << 4
+	gpioV2LineFlagEdgeFalling  = 1 << 5
+	gpioV2LineFlagBiasPullDown = 1 << 9
+
+	gpioV2LineEventRisingEdge = 1
+
+	gpioV2LineEventSize = 48
+)
+
+type gpioV2LineAttribute struct {
+	id      uint32
+	padding uint32
+	value   uint64
+}
+
+type gpioV2LineConfigAttribute struct {
+	attr gpioV2LineAttribute
+	mask uint64
+}
+
+type gpioV2LineConfig struct {
+	flags    uint64
+	numAttrs uint32
+	padding  [5]uint32
+	attrs    [10]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	offsets         [64]uint32
+	consumer        [32]byte
+	config          gpioV2LineConfig
+	numLines        uint32
+	eventBufferSize uint32
+	padding         [5]uint32
+	fd              int32
+}
+
+// gpioV2GetLineIoctl is _IOWR(0xB4, 0x07, struct gpio_v2_line_request).
+var gpioV2GetLineIoctl = uintptr(3<<30 | unsafe.Sizeof(gpioV2LineRequest{})<<16 | 0xB4<<8 | 0x07)
+
+// gpioLine is an EdgeSource reading edge events from a line
+// requested from a gpio character device.
+type gpioLine struct {
+	f *os.File
+}
+
+func openGPIOLine(chip string, line int) (EdgeSource, error) {
+	c, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	req := gpioV2LineRequest{numLines: 1}
+	req.offsets[0] = uint32(line)
+	copy(req.consumer[:], "argononefan")
+	req.config.flags = gpioV2LineFlagInput | gpioV2LineFlagEdgeRising | gpioV2LineFlagEdgeFalling | gpioV2LineFlagBiasPullDown
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, c.Fd(), gpioV2GetLineIoctl, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return nil, fmt.Errorf("requesting line: %w", errno)
+	}
+
+	// Non-blocking, so that the runtime poller is used and
+	// closing the file unblocks a pending read.
+	if err := syscall.SetNonblock(int(req.fd), true); err != nil {
+		syscall.Close(int(req.fd))
+		return nil, fmt.Errorf("setting line to non-blocking mode: %w", err)
+	}
+
+	return &gpioLine{f: os.NewFile(uintptr(req.fd), fmt.Sprintf("%s:%d", chip, line))}, nil
+}
+
+func (l *gpioLine) ReadEdge() (Edge, error) {
+	buf := make([]byte, gpioV2LineEventSize)
+	if _, err := io.ReadFull(l.f, buf); err != nil {
+		return Edge{}, err
+	}
+	return Edge{
+		Timestamp: time.Duration(binary.NativeEndian.Uint64(buf[0:8])),
+		Rising:    binary.NativeEndian.Uint32(buf[8:12]) == gpioV2LineEventRisingEdge,
+	}, nil
+}
+
+func (l *gpioLine) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  button_other.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import "errors"
+
+func openGPIOLine(chip string, line int) (EdgeSource, error) {
+	return nil, errors.New("gpio character devices are only supported on Linux")
+}
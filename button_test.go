@@ -0,0 +1,50 @@
+package argononefan
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEdges chan Edge
+
+func (f fakeEdges) ReadEdge() (Edge, error) {
+	e, ok := <-f
+	if !ok {
+		return Edge{}, errors.New("closed")
+	}
+	return e, nil
+}
+
+func (f fakeEdges) Close() error { return nil }
+
+func TestPowerButton(t *testing.T) {
+	edges := make(fakeEdges, 8)
+	pb, err := NewPowerButton(WithEdgeSource(edges))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := pb.Listen(ctx)
+	require.NoError(t, err)
+
+	pulse := func(start, width time.Duration) {
+		edges <- Edge{Rising: true, Timestamp: start}
+		edges <- Edge{Rising: false, Timestamp: start + width}
+	}
+
+	pulse(0, 5*time.Millisecond) // noise
+	pulse(time.Second, 20*time.Millisecond)
+	assert.Equal(t, ButtonDoubleTap, <-events)
+
+	pulse(2*time.Second, 40*time.Millisecond)
+	assert.Equal(t, ButtonLongPress, <-events)
+
+	close(edges)
+	_, open := <-events
+	assert.False(t, open)
+}
@@ -0,0 +1,76 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  button.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"os/exec"
+
+	"github.com/mwmahlberg/argononefan"
+)
+
+// buttonAction is the action to run on a power button event.
+// Besides the keywords reboot, poweroff and none, any other value
+// is run as a command by /bin/sh.
+type buttonAction string
+
+const (
+	actionNone     buttonAction = "none"
+	actionReboot   buttonAction = "reboot"
+	actionPoweroff buttonAction = "poweroff"
+)
+
+func (a buttonAction) command() *exec.Cmd {
+	switch a {
+	case actionNone, "":
+		return nil
+	case actionReboot:
+		return exec.Command("systemctl", "reboot")
+	case actionPoweroff:
+		return exec.Command("systemctl", "poweroff")
+	}
+	return exec.Command("/bin/sh", "-c", string(a))
+}
+
+func (d *daemonCmd) buttonActionFor(ev argononefan.ButtonEvent) buttonAction {
+	switch ev {
+	case argononefan.ButtonDoubleTap:
+		return d.ButtonDoubleTap
+	case argononefan.ButtonLongPress:
+		return d.ButtonLongPress
+	}
+	return actionNone
+}
+
+// handleButton runs the action configured for ev.
+// It is run in its own goroutine, so that long running
+// commands do not block the daemon.
+func (d *daemonCmd) handleButton(ev argononefan.ButtonEvent) {
+	action := d.buttonActionFor(ev)
+	cmd := action.command()
+	if cmd == nil {
+		d.logger.Info("Power button pressed, no action configured", "event", ev)
+		return
+	}
+
+	d.logger.Info("Power button pressed", "event", ev, "action", action)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		d.logger.Error("Running power button action", "event", ev, "action", action, "error", err, "output", string(out))
+	}
+}
@@ -0,0 +1,505 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  config.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
+	"gopkg.in/yaml.v3"
+)
+
+// configFlagName is the name of the flag holding the path to the config file.
+const configFlagName = "config"
+
+// configError is a validation error pointing at a line of the config file.
+type configError struct {
+	line int
+	msg  string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+func errorAt(node *yaml.Node, format string, args ...any) error {
+	return &configError{line: node.Line, msg: fmt.Sprintf(format, args...)}
+}
+
+// configValue is a value of the config file, remembering whether
+// it was set and where.
+type configValue[T any] struct {
+	value T
+	node  *yaml.Node
+}
+
+func (v *configValue[T]) UnmarshalYAML(node *yaml.Node) error {
+	if err := node.Decode(&v.value); err != nil {
+		var cerr *configError
+		if errors.As(err, &cerr) {
+			return err
+		}
+		return errorAt(node, "%s", strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n  "))
+	}
+	v.node = node
+	return nil
+}
+
+func (v *configValue[T]) isSet() bool {
+	return v.node != nil
+}
+
+type thresholdConfig struct {
+	Temperature float32 `yaml:"temperature"`
+	Speed       int     `yaml:"speed"`
+	node        *yaml.Node
+}
+
+func (t *thresholdConfig) UnmarshalYAML(node *yaml.Node) error {
+	// A distinct type without the UnmarshalYAML method, to avoid recursion.
+	type plain thresholdConfig
+	if err := node.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	t.node = node
+	// Checked like the thresholds flags, to which the thresholds are passed.
+	if err := (&control.Thresholds{}).UnmarshalText([]byte(thresholdsFlag([]thresholdConfig{*t}))); err != nil {
+		return errorAt(node, "%s", err)
+	}
+	return nil
+}
+
+type sensorConfig struct {
+	Name       string                         `yaml:"name"`
+	Path       string                         `yaml:"path"`
+	Weight     configValue[float64]           `yaml:"weight"`
+	Unit       configValue[string]            `yaml:"unit"`
+	Thresholds configValue[[]thresholdConfig] `yaml:"thresholds"`
+	node       *yaml.Node
+}
+
+func (s *sensorConfig) UnmarshalYAML(node *yaml.Node) error {
+	// A distinct type without the UnmarshalYAML method, to avoid recursion.
+	type plain sensorConfig
+	if err := node.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	s.node = node
+	return nil
+}
+
+// config is the schema of the config file.
+type config struct {
+	DeviceFile configValue[string]                  `yaml:"device-file"`
+	Sensors    configValue[[]sensorConfig]          `yaml:"sensors"`
+	Aggregate  configValue[argononefan.Aggregation] `yaml:"aggregate"`
+	Socket     configValue[string]                  `yaml:"socket"`
+	Bus        configValue[int]                     `yaml:"bus"`
+	Model      configValue[argononefan.Model]       `yaml:"model"`
+	Fan        struct {
+		Retries configValue[int]  `yaml:"retries"`
+		Verify  configValue[bool] `yaml:"verify"`
+	} `yaml:"fan"`
+	Logging struct {
+		Debug configValue[bool] `yaml:"debug"`
+	} `yaml:"logging"`
+	Daemon struct {
+		Mode       configValue[string]            `yaml:"mode"`
+		Thresholds configValue[[]thresholdConfig] `yaml:"thresholds"`
+		Hysteresis configValue[float32]           `yaml:"hysteresis"`
+		Curve      configValue[string]            `yaml:"curve"`
+		Interval   configValue[time.Duration]     `yaml:"interval"`
+		Adaptive   struct {
+			Enabled     configValue[bool]          `yaml:"enabled"`
+			MinInterval configValue[time.Duration] `yaml:"min-interval"`
+			MaxInterval configValue[time.Duration] `yaml:"max-interval"`
+		} `yaml:"adaptive"`
+		PID struct {
+			Target           configValue[float32]       `yaml:"target"`
+			Kp               configValue[float64]       `yaml:"kp"`
+			Ki               configValue[float64]       `yaml:"ki"`
+			Kd               configValue[float64]       `yaml:"kd"`
+			MinSpin          configValue[int]           `yaml:"min-spin"`
+			DerivativeFilter configValue[time.Duration] `yaml:"derivative-filter"`
+		} `yaml:"pid"`
+		Filter struct {
+			Type   configValue[string]  `yaml:"type"`
+			Window configValue[int]     `yaml:"window"`
+			Alpha  configValue[float32] `yaml:"alpha"`
+		} `yaml:"filter"`
+		SensorFailure struct {
+			MaxFailures   configValue[int]  `yaml:"max-failures"`
+			FailSafeSpeed configValue[int]  `yaml:"fail-safe-speed"`
+			Exit          configValue[bool] `yaml:"exit"`
+		} `yaml:"sensor-failure"`
+		Metrics struct {
+			Bind           configValue[string] `yaml:"bind"`
+			Path           configValue[string] `yaml:"path"`
+			ReadyIntervals configValue[int]    `yaml:"ready-intervals"`
+			TLS            struct {
+				Cert configValue[string] `yaml:"cert"`
+				Key  configValue[string] `yaml:"key"`
+			} `yaml:"tls"`
+			BasicAuth struct {
+				User         configValue[string] `yaml:"user"`
+				PasswordFile configValue[string] `yaml:"password-file"`
+			} `yaml:"basic-auth"`
+		} `yaml:"metrics"`
+		Button struct {
+			Enabled   configValue[bool]   `yaml:"enabled"`
+			Chip      configValue[string] `yaml:"chip"`
+			Line      configValue[int]    `yaml:"line"`
+			DoubleTap configValue[string] `yaml:"double-tap"`
+			LongPress configValue[string] `yaml:"long-press"`
+		} `yaml:"button"`
+	} `yaml:"daemon"`
+}
+
+// parseConfig parses and validates a config file.
+func parseConfig(in io.Reader) (*config, error) {
+	b, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	cfg := &config{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, humanizeYAMLError(err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+var unknownFieldRe = regexp.MustCompile(`field (\S+) not found in type .*`)
+
+// humanizeYAMLError removes the Go types from the errors of the yaml decoder.
+func humanizeYAMLError(err error) error {
+	var terr *yaml.TypeError
+	if !errors.As(err, &terr) {
+		return err
+	}
+	msgs := make([]string, len(terr.Errors))
+	for i, msg := range terr.Errors {
+		msgs[i] = unknownFieldRe.ReplaceAllString(msg, "unknown key '$1'")
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func (c *config) validate() error {
+	if c.Bus.isSet() && c.Bus.value < 0 {
+		return errorAt(c.Bus.node, "bus must not be negative: %d", c.Bus.value)
+	}
+
+	if r := c.Fan.Retries; r.isSet() && r.value < 0 {
+		return errorAt(r.node, "number of fan retries must not be negative: %d", r.value)
+	}
+
+	if err := validateThresholds(c.Daemon.Thresholds); err != nil {
+		return err
+	}
+
+	if sensors := c.Sensors; sensors.isSet() {
+		seen := make(map[string]bool)
+		for _, s := range sensors.value {
+			switch {
+			case s.Name == "":
+				return errorAt(s.node, "sensor without name")
+			case seen[s.Name]:
+				return errorAt(s.node, "duplicate sensor '%s'", s.Name)
+			case s.Path == "":
+				return errorAt(s.node, "sensor '%s' without path", s.Name)
+			case s.Weight.isSet() && s.Weight.value <= 0:
+				return errorAt(s.Weight.node, "weight of sensor '%s' must be positive: %g", s.Name, s.Weight.value)
+			case s.Unit.isSet() && (s.Unit.value == "" || sensorUnits[s.Unit.value] == ""):
+				return errorAt(s.Unit.node, "unknown unit '%s' of sensor '%s': must be one of millicelsius or celsius", s.Unit.value, s.Name)
+			}
+			seen[s.Name] = true
+			if err := validateThresholds(s.Thresholds); err != nil {
+				return err
+			}
+		}
+	}
+
+	if a := c.Aggregate; a.isSet() {
+		switch a.value {
+		case argononefan.AggregateMax, argononefan.AggregateAverage:
+		default:
+			return errorAt(a.node, "unknown aggregation '%s': must be one of max or average", a.value)
+		}
+	}
+
+	if h := c.Daemon.Hysteresis; h.isSet() && h.value < 0 {
+		return errorAt(h.node, "hysteresis must not be negative: %2.1f", h.value)
+	}
+
+	if m := c.Daemon.Mode; m.isSet() {
+		switch controlMode(m.value) {
+		case modeThresholds, modePID:
+		default:
+			return errorAt(m.node, "unknown mode '%s': must be one of thresholds or pid", m.value)
+		}
+	}
+
+	for _, g := range []configValue[float64]{c.Daemon.PID.Kp, c.Daemon.PID.Ki, c.Daemon.PID.Kd} {
+		if g.isSet() && g.value < 0 {
+			return errorAt(g.node, "gain must not be negative: %g", g.value)
+		}
+	}
+
+	if ms := c.Daemon.PID.MinSpin; ms.isSet() && (ms.value < 0 || ms.value > 100) {
+		return errorAt(ms.node, "minimum spin is out of range: %d", ms.value)
+	}
+
+	if cm := c.Daemon.Curve; cm.isSet() {
+		switch control.Curve(cm.value) {
+		case control.CurveStep, control.CurveLinear, control.CurveSpline:
+		default:
+			return errorAt(cm.node, "unknown curve '%s': must be one of step, linear or spline", cm.value)
+		}
+	}
+
+	if f := c.Daemon.Filter.Type; f.isSet() {
+		switch filterKind(f.value) {
+		case filterNone, filterAverage, filterEMA, filterMedian:
+		default:
+			return errorAt(f.node, "unknown filter '%s': must be one of none, average, ema or median", f.value)
+		}
+	}
+
+	if w := c.Daemon.Filter.Window; w.isSet() && w.value < 1 {
+		return errorAt(w.node, "filter window must be at least 1: %d", w.value)
+	}
+
+	if a := c.Daemon.Filter.Alpha; a.isSet() && (a.value <= 0 || a.value > 1) {
+		return errorAt(a.node, "filter alpha must be in (0, 1]: %g", a.value)
+	}
+
+	if i := c.Daemon.Interval; i.isSet() && i.value <= 0 {
+		return errorAt(i.node, "interval must be positive: %s", i.value)
+	}
+
+	for _, i := range []configValue[time.Duration]{c.Daemon.Adaptive.MinInterval, c.Daemon.Adaptive.MaxInterval} {
+		if i.isSet() && i.value <= 0 {
+			return errorAt(i.node, "interval must be positive: %s", i.value)
+		}
+	}
+
+	if m := c.Daemon.SensorFailure.MaxFailures; m.isSet() && m.value < 1 {
+		return errorAt(m.node, "maximum number of failures must be at least 1: %d", m.value)
+	}
+
+	if s := c.Daemon.SensorFailure.FailSafeSpeed; s.isSet() && (s.value < 1 || s.value > 100) {
+		return errorAt(s.node, "fail-safe speed is out of range: %d", s.value)
+	}
+
+	if p := c.Daemon.Metrics.Path; p.isSet() && !strings.HasPrefix(p.value, "/") {
+		return errorAt(p.node, "metrics path must start with '/': %s", p.value)
+	}
+
+	if r := c.Daemon.Metrics.ReadyIntervals; r.isSet() && r.value < 1 {
+		return errorAt(r.node, "ready intervals must be at least 1: %d", r.value)
+	}
+
+	if l := c.Daemon.Button.Line; l.isSet() && l.value < 0 {
+		return errorAt(l.node, "button line must not be negative: %d", l.value)
+	}
+
+	return nil
+}
+
+func validateThresholds(th configValue[[]thresholdConfig]) error {
+	if !th.isSet() {
+		return nil
+	}
+	if len(th.value) == 0 {
+		return errorAt(th.node, "at least one threshold is required")
+	}
+	seen := make(map[float32]bool)
+	for _, t := range th.value {
+		if seen[t.Temperature] {
+			return errorAt(t.node, "duplicate threshold for %2.1f°C", t.Temperature)
+		}
+		seen[t.Temperature] = true
+	}
+	return nil
+}
+
+// thresholdsFlag formats thresholds as expected by the thresholds flags.
+func thresholdsFlag(thresholds []thresholdConfig) string {
+	pairs := make([]string, 0, len(thresholds))
+	for _, t := range thresholds {
+		pairs = append(pairs, strconv.FormatFloat(float64(t.Temperature), 'f', -1, 32)+"="+strconv.Itoa(t.Speed))
+	}
+	return strings.Join(pairs, ";")
+}
+
+// flagValues maps the names of the flags to the values set in the config file.
+// Map flags get a map of their keys to the values.
+func (c *config) flagValues() map[string]any {
+	values := make(map[string]any)
+	set := func(name string, isSet bool, value any) {
+		if isSet {
+			values[name] = fmt.Sprint(value)
+		}
+	}
+
+	set("device-file", c.DeviceFile.isSet(), c.DeviceFile.value)
+	set("aggregate", c.Aggregate.isSet(), c.Aggregate.value)
+	if c.Sensors.isSet() {
+		sensors, weights, units, thresholds := make(map[string]any), make(map[string]any), make(map[string]any), make(map[string]any)
+		for _, s := range c.Sensors.value {
+			sensors[s.Name] = s.Path
+			if s.Weight.isSet() {
+				weights[s.Name] = s.Weight.value
+			}
+			if s.Unit.isSet() {
+				units[s.Name] = s.Unit.value
+			}
+			if s.Thresholds.isSet() {
+				thresholds[s.Name] = thresholdsFlag(s.Thresholds.value)
+			}
+		}
+		values["sensor"] = sensors
+		if len(weights) > 0 {
+			values["sensor-weight"] = weights
+		}
+		if len(units) > 0 {
+			values["sensor-unit"] = units
+		}
+		if len(thresholds) > 0 {
+			values["sensor-thresholds"] = thresholds
+		}
+	}
+	set("socket", c.Socket.isSet(), c.Socket.value)
+	set("bus", c.Bus.isSet(), c.Bus.value)
+	set("model", c.Model.isSet(), c.Model.value)
+	set("fan-retries", c.Fan.Retries.isSet(), c.Fan.Retries.value)
+	set("fan-verify", c.Fan.Verify.isSet(), c.Fan.Verify.value)
+	set("debug", c.Logging.Debug.isSet(), c.Logging.Debug.value)
+
+	d := c.Daemon
+	set("mode", d.Mode.isSet(), d.Mode.value)
+	if d.Thresholds.isSet() {
+		values["thresholds"] = thresholdsFlag(d.Thresholds.value)
+	}
+	set("hysteresis", d.Hysteresis.isSet(), d.Hysteresis.value)
+	set("curve", d.Curve.isSet(), d.Curve.value)
+	set("check-interval", d.Interval.isSet(), d.Interval.value)
+	set("adaptive", d.Adaptive.Enabled.isSet(), d.Adaptive.Enabled.value)
+	set("min-interval", d.Adaptive.MinInterval.isSet(), d.Adaptive.MinInterval.value)
+	set("max-interval", d.Adaptive.MaxInterval.isSet(), d.Adaptive.MaxInterval.value)
+	set("pid-target", d.PID.Target.isSet(), d.PID.Target.value)
+	set("pid-kp", d.PID.Kp.isSet(), d.PID.Kp.value)
+	set("pid-ki", d.PID.Ki.isSet(), d.PID.Ki.value)
+	set("pid-kd", d.PID.Kd.isSet(), d.PID.Kd.value)
+	set("pid-min-spin", d.PID.MinSpin.isSet(), d.PID.MinSpin.value)
+	set("pid-derivative-filter", d.PID.DerivativeFilter.isSet(), d.PID.DerivativeFilter.value)
+	set("filter", d.Filter.Type.isSet(), d.Filter.Type.value)
+	set("filter-window", d.Filter.Window.isSet(), d.Filter.Window.value)
+	set("filter-alpha", d.Filter.Alpha.isSet(), d.Filter.Alpha.value)
+	set("max-failures", d.SensorFailure.MaxFailures.isSet(), d.SensorFailure.MaxFailures.value)
+	set("fail-safe-speed", d.SensorFailure.FailSafeSpeed.isSet(), d.SensorFailure.FailSafeSpeed.value)
+	set("exit-on-failure", d.SensorFailure.Exit.isSet(), d.SensorFailure.Exit.value)
+	set("prometheus-bind", d.Metrics.Bind.isSet(), d.Metrics.Bind.value)
+	set("metrics-path", d.Metrics.Path.isSet(), d.Metrics.Path.value)
+	set("ready-intervals", d.Metrics.ReadyIntervals.isSet(), d.Metrics.ReadyIntervals.value)
+	set("tls-cert", d.Metrics.TLS.Cert.isSet(), d.Metrics.TLS.Cert.value)
+	set("tls-key", d.Metrics.TLS.Key.isSet(), d.Metrics.TLS.Key.value)
+	set("basic-auth-user", d.Metrics.BasicAuth.User.isSet(), d.Metrics.BasicAuth.User.value)
+	set("basic-auth-password-file", d.Metrics.BasicAuth.PasswordFile.isSet(), d.Metrics.BasicAuth.PasswordFile.value)
+	set("button", d.Button.Enabled.isSet(), d.Button.Enabled.value)
+	set("button-chip", d.Button.Chip.isSet(), d.Button.Chip.value)
+	set("button-line", d.Button.Line.isSet(), d.Button.Line.value)
+	set("button-double-tap", d.Button.DoubleTap.isSet(), d.Button.DoubleTap.value)
+	set("button-long-press", d.Button.LongPress.isSet(), d.Button.LongPress.value)
+
+	return values
+}
+
+// configResolver is a kong.Resolver providing the values of the config file
+// passed with --config for all flags neither set on the command line
+// nor via environment variables.
+//
+// Hence, the precedence is: flags, environment variables, config file, defaults.
+type configResolver struct {
+	values map[string]any
+}
+
+func (r *configResolver) Validate(app *kong.Application) error {
+	return nil
+}
+
+func (r *configResolver) Resolve(ctx *kong.Context, parent *kong.Path, flag *kong.Flag) (any, error) {
+	if flag.Name == configFlagName {
+		return nil, nil
+	}
+
+	for _, env := range flag.Tag.Envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, nil
+		}
+	}
+
+	if v, ok := r.values[flag.Name]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+// load reads the config file set via flag or environment variable, if any.
+func (r *configResolver) load(ctx *kong.Context) error {
+	var path string
+	for _, f := range ctx.Flags() {
+		if f.Name == configFlagName {
+			path, _ = ctx.FlagValue(f).(string)
+		}
+	}
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Open(kong.ExpandPath(path))
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer f.Close()
+
+	cfg, err := parseConfig(f)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	r.values = cfg.flagValues()
+	return nil
+}
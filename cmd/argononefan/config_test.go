@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(`
+bus: 1
+model: v3
+logging:
+  debug: true
+sensors:
+  - name: cpu
+    path: /sys/class/thermal/thermal_zone0/temp
+  - name: nvme
+    path: /sys/class/hwmon/hwmon1/temp1_input
+    weight: 2
+    thresholds:
+      - {temperature: 55, speed: 100}
+      - {temperature: 45, speed: 30}
+aggregate: average
+daemon:
+  thresholds:
+    - temperature: 70
+      speed: 100
+    - temperature: 62.5
+      speed: 50
+  hysteresis: 2
+  interval: 10s
+  filter:
+    type: median
+    window: 3
+  metrics:
+    bind: ":9100"
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"bus":       "1",
+		"model":     "v3",
+		"aggregate": "average",
+		"sensor": map[string]any{
+			"cpu":  "/sys/class/thermal/thermal_zone0/temp",
+			"nvme": "/sys/class/hwmon/hwmon1/temp1_input",
+		},
+		"sensor-weight":     map[string]any{"nvme": float64(2)},
+		"sensor-thresholds": map[string]any{"nvme": "55=100;45=30"},
+		"debug":             "true",
+		"thresholds":        "70=100;62.5=50",
+		"hysteresis":        "2",
+		"check-interval":    "10s",
+		"filter":            "median",
+		"filter-window":     "3",
+		"prometheus-bind":   ":9100",
+	}, cfg.flagValues())
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		config string
+		err    string
+	}{
+		{"unknown key", "bus: 1\nfoo: 2\n", "line 2: unknown key 'foo'"},
+		{"wrong type", "bus: one\n", "line 1:"},
+		{"invalid model", "model: v9\n", "line 1: unknown model 'v9'"},
+		{"speed out of range", "daemon:\n  thresholds:\n    - temperature: 70\n      speed: 200\n", "line 3: fan speed for 70.0°C is out of range: 200"},
+		{"duplicate threshold", "daemon:\n  thresholds:\n    - {temperature: 70, speed: 100}\n    - {temperature: 70, speed: 50}\n", "line 4: duplicate threshold"},
+		{"empty thresholds", "daemon:\n  thresholds: []\n", "line 2: at least one threshold is required"},
+		{"duplicate sensor", "sensors:\n  - {name: cpu, path: /a}\n  - {name: cpu, path: /b}\n", "line 3: duplicate sensor 'cpu'"},
+		{"zero weight", "sensors:\n  - {name: cpu, path: /a, weight: 0}\n", "line 2: weight of sensor 'cpu' must be positive"},
+		{"unknown aggregation", "aggregate: min\n", "line 1: unknown aggregation 'min'"},
+		{"unknown filter", "daemon:\n  filter:\n    type: kalman\n", "line 3: unknown filter 'kalman'"},
+		{"negative hysteresis", "daemon:\n  hysteresis: -1\n", "line 2: hysteresis must not be negative"},
+		{"zero interval", "daemon:\n  interval: 0s\n", "line 2: interval must be positive"},
+		{"zero fail-safe speed", "daemon:\n  sensor-failure:\n    fail-safe-speed: 0\n", "line 3: fail-safe speed is out of range: 0"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := parseConfig(strings.NewReader(tC.config))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tC.err)
+		})
+	}
+}
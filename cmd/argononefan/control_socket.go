@@ -0,0 +1,178 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  control_socket.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/mwmahlberg/argononefan/daemon"
+)
+
+// The control socket takes one JSON encoded ctlRequest per connection
+// and answers with one JSON encoded ctlResponse.
+
+// ctlTimeout limits the time a request on the control socket may take.
+const ctlTimeout = 5 * time.Second
+
+// The commands understood on the control socket.
+const (
+	ctlStatus        = "status"
+	ctlOverride      = "override"
+	ctlClearOverride = "clear-override"
+	ctlPause         = "pause"
+	ctlResume        = "resume"
+	ctlGetThresholds = "get-thresholds"
+	ctlSetThresholds = "set-thresholds"
+)
+
+type ctlRequest struct {
+	Command string `json:"command"`
+	// Speed in percent for override.
+	Speed int `json:"speed,omitempty"`
+	// TTL of an override, like 10m. Empty or 0 for none.
+	TTL string `json:"ttl,omitempty"`
+	// Thresholds for set-thresholds, in the format of --thresholds.
+	Thresholds string `json:"thresholds,omitempty"`
+}
+
+type ctlResponse struct {
+	Error      string        `json:"error,omitempty"`
+	Status     *daemonStatus `json:"status,omitempty"`
+	Thresholds string        `json:"thresholds,omitempty"`
+}
+
+// listenControl listens on the control socket at path,
+// replacing a stale socket left behind by a previous run.
+func listenControl(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating directory of control socket: %w", err)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("control socket %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale control socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on control socket: %w", err)
+	}
+	// Only root and the group may control the fan.
+	if err := os.Chmod(path, 0o660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("setting permissions of control socket: %w", err)
+	}
+	return l, nil
+}
+
+// serveControl answers requests on l until it is closed.
+func (d *daemonCmd) serveControl(l net.Listener, dmn *daemon.Daemon) {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			d.logger.Error("Accepting connection on control socket", "error", err)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(ctlTimeout))
+
+			var req ctlRequest
+			var resp ctlResponse
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				resp.Error = fmt.Sprintf("decoding request: %s", err)
+			} else {
+				d.logger.Debug("Control request", "command", req.Command)
+				resp = d.handleControl(req, dmn)
+			}
+			if err := json.NewEncoder(conn).Encode(resp); err != nil {
+				d.logger.Error("Answering control request", "error", err)
+			}
+		}()
+	}
+}
+
+// handleControl executes req on dmn.
+func (d *daemonCmd) handleControl(req ctlRequest, dmn *daemon.Daemon) ctlResponse {
+	var err error
+	switch req.Command {
+	case ctlStatus:
+		return ctlResponse{Status: d.status(dmn)}
+	case ctlOverride:
+		var ttl time.Duration
+		if req.TTL != "" {
+			if ttl, err = time.ParseDuration(req.TTL); err != nil {
+				return ctlResponse{Error: fmt.Sprintf("parsing ttl: %s", err)}
+			}
+		}
+		err = dmn.SetOverride(req.Speed, ttl)
+	case ctlClearOverride:
+		dmn.ClearOverride()
+	case ctlPause:
+		dmn.Pause()
+	case ctlResume:
+		dmn.Resume()
+	case ctlGetThresholds:
+	case ctlSetThresholds:
+		err = d.setThresholds(req.Thresholds, dmn)
+	default:
+		return ctlResponse{Error: fmt.Sprintf("unknown command '%s'", req.Command)}
+	}
+	if err != nil {
+		return ctlResponse{Error: err.Error()}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ctlResponse{Thresholds: d.Thresholds.String()}
+}
+
+// setThresholds replaces the thresholds of the running daemon.
+// They are reset to the configured ones on reload.
+func (d *daemonCmd) setThresholds(text string, dmn *daemon.Daemon) error {
+	t := &control.Thresholds{}
+	if err := t.UnmarshalText([]byte(text)); err != nil {
+		return fmt.Errorf("parsing thresholds: %w", err)
+	}
+	if len(t.Temperatures()) == 0 {
+		return errors.New("at least one threshold is required")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	previous := d.settings()
+	d.Thresholds = t
+	d.logger.Info("Thresholds set via control socket", "thresholds", t)
+	return d.apply(previous, dmn)
+}
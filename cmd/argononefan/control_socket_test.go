@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/mwmahlberg/argononefan/daemon"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startDaemon runs the daemon configured by d until the test ends,
+// reading a constant 65°C and driving an in-memory fan.
+func startDaemon(t *testing.T, d *daemonCmd) *daemon.Daemon {
+	tempFile := filepath.Join(t.TempDir(), "temp")
+	require.NoError(t, os.WriteFile(tempFile, []byte("65000\n"), 0o644))
+	tr, err := argononefan.NewThermalReader(argononefan.WithThermalDeviceFile(tempFile))
+	require.NoError(t, err)
+	fan, err := argononefan.Connect(argononefan.WithI2CBus(argononefan.NewMemoryI2CBus()))
+	require.NoError(t, err)
+
+	s := d.settings()
+	d.controller = s.controller()
+	dmn, err := daemon.New(append([]daemon.Option{
+		daemon.WithReader(tr),
+		daemon.WithFan(fan),
+		daemon.WithRegistry(prometheus.NewRegistry()),
+	}, s.options(d.controller)...)...)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go dmn.Run(ctx)
+	return dmn
+}
+
+func TestControlSocket(t *testing.T) {
+	d := &daemonCmd{
+		Mode:          modeThresholds,
+		Thresholds:    control.NewThresholds(map[float32]int{70: 100, 60: 50}),
+		CheckInterval: time.Millisecond,
+		MaxFailures:   3,
+		FailSafeSpeed: 100,
+		logger:        hclog.NewNullLogger(),
+	}
+	dmn := startDaemon(t, d)
+	dir := t.TempDir()
+
+	socket := ctlSocket(filepath.Join(dir, "run", "control.sock"))
+	l, err := listenControl(string(socket))
+	require.NoError(t, err)
+	defer l.Close()
+	go d.serveControl(l, dmn)
+
+	speedEventually := func(speed int) {
+		assert.Eventually(t, func() bool {
+			resp, err := socket.do(ctlRequest{Command: ctlStatus})
+			return err == nil && resp.Status.Speed == speed
+		}, time.Second, time.Millisecond)
+	}
+	speedEventually(50)
+
+	_, err = socket.do(ctlRequest{Command: ctlOverride, Speed: 100, TTL: "1h"})
+	require.NoError(t, err)
+	speedEventually(100)
+	resp, err := socket.do(ctlRequest{Command: ctlStatus})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Status.Override)
+	assert.NotNil(t, resp.Status.Override.Until)
+
+	_, err = socket.do(ctlRequest{Command: ctlClearOverride})
+	require.NoError(t, err)
+	speedEventually(50)
+
+	resp, err = socket.do(ctlRequest{Command: ctlSetThresholds, Thresholds: "65=80"})
+	require.NoError(t, err)
+	assert.Equal(t, "65=80", resp.Thresholds)
+	speedEventually(80)
+
+	_, err = socket.do(ctlRequest{Command: ctlSetThresholds, Thresholds: "hot"})
+	assert.ErrorContains(t, err, "parsing thresholds")
+	failed := d.handleControl(ctlRequest{Command: ctlSetThresholds, Thresholds: "70=150"}, dmn)
+	assert.Contains(t, failed.Error, "fan speed for 70.0°C is out of range: 150", "a speed the fan rejects must not be set")
+	assert.Equal(t, "65=80", d.Thresholds.String())
+	_, err = socket.do(ctlRequest{Command: "explode"})
+	assert.ErrorContains(t, err, "unknown command 'explode'")
+}
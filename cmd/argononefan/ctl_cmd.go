@@ -0,0 +1,182 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  ctl_cmd.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// ctlSocket is the path of the control socket of the daemon.
+type ctlSocket string
+
+// do sends req to the daemon listening on s and returns its response.
+func (s ctlSocket) do(req ctlRequest) (*ctlResponse, error) {
+	if s == "" {
+		return nil, errors.New("no control socket set")
+	}
+	conn, err := net.DialTimeout("unix", string(s), ctlTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to daemon: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ctlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	resp := &ctlResponse{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("daemon: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+type ctlCmd struct {
+	Status        ctlStatusCmd        `kong:"cmd,help='Show the status of the daemon'"`
+	Override      ctlOverrideCmd      `kong:"cmd,help='Set the fan speed regardless of the temperature'"`
+	ClearOverride ctlClearOverrideCmd `kong:"cmd,help='Let the daemon control the fan speed again after override'"`
+	Pause         ctlPauseCmd         `kong:"cmd,help='Keep the daemon from changing the fan speed'"`
+	Resume        ctlResumeCmd        `kong:"cmd,help='Let the daemon change the fan speed again after pause'"`
+	Thresholds    ctlThresholdsCmd    `kong:"cmd,help='Show or set the thresholds until the next reload'"`
+}
+
+type ctlStatusCmd struct {
+	JSON bool `name:"json" help:"Print the status as JSON"`
+}
+
+func (c *ctlStatusCmd) Run(socket ctlSocket) error {
+	resp, err := socket.do(ctlRequest{Command: ctlStatus})
+	if err != nil {
+		return err
+	}
+	s := resp.Status
+	if s == nil {
+		return errors.New("daemon sent no status")
+	}
+	if c.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	lastReading := "never"
+	if s.LastReading != nil {
+		lastReading = s.LastReading.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "Temperature:\t%2.1f°C (read %s)\n", s.Temperature, lastReading)
+	names := maps.Keys(s.Sensors)
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s:\t%2.1f°C\n", name, s.Sensors[name])
+	}
+	fmt.Fprintf(w, "Speed:\t%d%% (target %d%%)\n", s.Speed, s.TargetSpeed)
+	fmt.Fprintf(w, "Mode:\t%s\n", s.Config.Mode)
+	fmt.Fprintf(w, "Thresholds:\t%s (curve %s)\n", s.Config.Thresholds, s.Config.Curve)
+	if s.ActiveThreshold != nil {
+		fmt.Fprintf(w, "Active threshold:\t%2.1f°C (hysteresis holding: %t)\n", *s.ActiveThreshold, s.HysteresisHolding)
+	}
+	fmt.Fprintf(w, "Interval:\t%s\n", s.Interval)
+	fmt.Fprintf(w, "Paused:\t%t\n", s.Paused)
+	switch o := s.Override; {
+	case o == nil:
+		fmt.Fprintf(w, "Override:\tnone\n")
+	case o.Until == nil:
+		fmt.Fprintf(w, "Override:\t%d%%\n", o.Speed)
+	default:
+		fmt.Fprintf(w, "Override:\t%d%% until %s\n", o.Speed, o.Until.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Failed readings:\t%d (fail-safe: %t)\n", s.Failures, s.FailSafe)
+	if e := s.LastError; e != nil {
+		fmt.Fprintf(w, "Last error:\t%s (%s)\n", e.Message, e.Time.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Version:\t%s (up %s)\n", s.Version, s.Uptime)
+	return w.Flush()
+}
+
+type ctlOverrideCmd struct {
+	Speed int           `arg:"" help:"Fan speed" required:""`
+	TTL   time.Duration `name:"ttl" help:"Time after which the daemon takes over again, 0 to keep the speed until clear-override" default:"10m"`
+}
+
+func (c *ctlOverrideCmd) Validate() error {
+	if c.Speed < 0 || c.Speed > 100 {
+		return fmt.Errorf("desired fan speed is out of range: %d", c.Speed)
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative: %s", c.TTL)
+	}
+	return nil
+}
+
+func (c *ctlOverrideCmd) Run(socket ctlSocket) error {
+	_, err := socket.do(ctlRequest{Command: ctlOverride, Speed: c.Speed, TTL: c.TTL.String()})
+	return err
+}
+
+type ctlClearOverrideCmd struct{}
+
+func (c *ctlClearOverrideCmd) Run(socket ctlSocket) error {
+	_, err := socket.do(ctlRequest{Command: ctlClearOverride})
+	return err
+}
+
+type ctlPauseCmd struct{}
+
+func (c *ctlPauseCmd) Run(socket ctlSocket) error {
+	_, err := socket.do(ctlRequest{Command: ctlPause})
+	return err
+}
+
+type ctlResumeCmd struct{}
+
+func (c *ctlResumeCmd) Run(socket ctlSocket) error {
+	_, err := socket.do(ctlRequest{Command: ctlResume})
+	return err
+}
+
+type ctlThresholdsCmd struct {
+	Thresholds string `arg:"" optional:"" help:"New thresholds, like 70=100;60=50;55=10. Omit to show the current ones"`
+}
+
+func (c *ctlThresholdsCmd) Run(socket ctlSocket) error {
+	req := ctlRequest{Command: ctlGetThresholds}
+	if c.Thresholds != "" {
+		req = ctlRequest{Command: ctlSetThresholds, Thresholds: c.Thresholds}
+	}
+	resp, err := socket.do(req)
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Thresholds)
+	return nil
+}
@@ -22,7 +22,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -30,44 +30,106 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/mwmahlberg/argononefan"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/mwmahlberg/argononefan/daemon"
 )
 
+// httpShutdownTimeout limits waiting for running requests on exit.
+const httpShutdownTimeout = 5 * time.Second
+
 type daemonCmd struct {
-	Thresholds     *thresholds   `short:"t" long:"threshold" help:"${help_thresholds}" default:"70=100;60=50;55=10"`
-	Hysteresis     float32       `long:"hysteresis" help:"${help_hysteresis}" default:"1.0"`
-	CheckInterval  time.Duration `short:"i" long:"interval" help:"Check interval" default:"5s"`
-	logger         hclog.Logger  `kong:"-"`
-	PrometheusBind string        `long:"promehteus-bind" help:"Address to bind the Prometheus metrics server to" default:"localhost:8080"`
+	Mode             controlMode                    `long:"mode" help:"Control mode: thresholds uses the thresholds and curve, pid holds the temperature at --pid-target" enum:"thresholds,pid" default:"thresholds"`
+	Thresholds       *control.Thresholds            `short:"t" long:"threshold" help:"${help_thresholds}" default:"70=100;60=50;55=10"`
+	Hysteresis       float32                        `long:"hysteresis" help:"${help_hysteresis}" default:"1.0"`
+	Curve            control.Curve                  `long:"curve" help:"${help_curve}" enum:"step,linear,spline" default:"step"`
+	SensorThresholds map[string]*control.Thresholds `name:"sensor-thresholds" help:"${help_sensor_thresholds}" mapsep:"none" placeholder:"NAME=THRESHOLDS"`
+	CheckInterval    time.Duration                  `short:"i" long:"interval" help:"Check interval" default:"5s"`
+	Adaptive         bool                           `long:"adaptive" help:"Poll faster when the temperature changes quickly or is close to a threshold, slower when it is stable" default:"false"`
+	MinInterval      time.Duration                  `long:"min-interval" help:"Minimum check interval in adaptive mode" default:"1s"`
+	MaxInterval      time.Duration                  `long:"max-interval" help:"Maximum check interval in adaptive mode" default:"30s"`
+	logger           hclog.Logger                   `kong:"-"`
+	controller       control.Controller             `kong:"-"`
+	// loaded is the configuration loaded last, on start or on reload.
+	loaded *cliFlags `kong:"-"`
+	// mu guards the settings changed at runtime.
+	mu sync.Mutex `kong:"-"`
+
+	PIDTarget           float32       `long:"pid-target" help:"Temperature in °C the PID controller holds" default:"55" group:"PID controller"`
+	PIDKp               float64       `long:"pid-kp" help:"Proportional gain in % per °C" default:"5" group:"PID controller"`
+	PIDKi               float64       `long:"pid-ki" help:"Integral gain in % per °C and second" default:"0.05" group:"PID controller"`
+	PIDKd               float64       `long:"pid-kd" help:"Derivative gain in % per °C per second" default:"20" group:"PID controller"`
+	PIDMinSpin          int           `long:"pid-min-spin" help:"Lowest speed in % the fan runs at, if it runs at all" default:"10" group:"PID controller"`
+	PIDDerivativeFilter time.Duration `long:"pid-derivative-filter" help:"Time constant of the low-pass filter applied to the derivative" default:"10s" group:"PID controller"`
+
+	Filter       filterKind `long:"filter" help:"Smoothing of the temperature readings: none, average (moving average), ema (exponential moving average) or median (outlier rejection)" enum:"none,average,ema,median" default:"none" group:"Smoothing"`
+	FilterWindow int        `long:"filter-window" help:"Number of readings the average and median filters use" default:"5" group:"Smoothing"`
+	FilterAlpha  float32    `long:"filter-alpha" help:"Weight of the newest reading for the ema filter, in (0, 1]: the lower, the smoother" default:"0.3" group:"Smoothing"`
+
+	MaxFailures   int  `long:"max-failures" help:"Number of consecutive failed readings after which the fan is set to --fail-safe-speed" default:"3" group:"Sensor failure"`
+	FailSafeSpeed int  `long:"fail-safe-speed" help:"Fan speed in % while the temperature can not be read" default:"100" group:"Sensor failure"`
+	ExitOnFailure bool `long:"exit-on-failure" help:"Exit with an error instead of setting --fail-safe-speed, so that systemd restarts the daemon" default:"false" group:"Sensor failure"`
+
+	PrometheusBind        string `long:"promehteus-bind" help:"Address to bind the HTTP server for metrics, health and readiness to" default:"localhost:8080" group:"HTTP server"`
+	MetricsPath           string `name:"metrics-path" help:"Path the Prometheus metrics are served at" default:"/metrics" group:"HTTP server"`
+	ReadyIntervals        int    `name:"ready-intervals" help:"The daemon is ready if the temperature was read within this many check intervals and setting the fan speed did not fail" default:"3" group:"HTTP server"`
+	TLSCert               string `name:"tls-cert" help:"Certificate file to serve HTTPS with" type:"path" group:"HTTP server"`
+	TLSKey                string `name:"tls-key" help:"Key file of the certificate" type:"path" group:"HTTP server"`
+	BasicAuthUser         string `name:"basic-auth-user" help:"User required to access the metrics" group:"HTTP server"`
+	BasicAuthPasswordFile string `name:"basic-auth-password-file" help:"File holding the password required to access the metrics" type:"path" group:"HTTP server"`
+
+	Button          bool         `long:"button" help:"Watch the power button of the case" default:"false" group:"Power button"`
+	ButtonChip      string       `long:"button-chip" help:"GPIO character device the power button is connected to" default:"/dev/gpiochip0" group:"Power button"`
+	ButtonLine      int          `long:"button-line" help:"GPIO line the power button is connected to" default:"4" group:"Power button"`
+	ButtonDoubleTap buttonAction `long:"button-double-tap" help:"${help_button_action}" default:"reboot" group:"Power button"`
+	ButtonLongPress buttonAction `long:"button-long-press" help:"${help_button_action}" default:"poweroff" group:"Power button"`
+}
+
+func (d *daemonCmd) Validate() error {
+	if d.PIDMinSpin < 0 || d.PIDMinSpin > 100 {
+		return fmt.Errorf("minimum spin of the PID controller is out of range: %d", d.PIDMinSpin)
+	}
+	if d.PIDKp < 0 || d.PIDKi < 0 || d.PIDKd < 0 {
+		return fmt.Errorf("gains of the PID controller must not be negative: kp=%g, ki=%g, kd=%g", d.PIDKp, d.PIDKi, d.PIDKd)
+	}
+	if d.FilterWindow < 1 {
+		return fmt.Errorf("filter window must be at least 1: %d", d.FilterWindow)
+	}
+	if d.FilterAlpha <= 0 || d.FilterAlpha > 1 {
+		return fmt.Errorf("filter alpha must be in (0, 1]: %g", d.FilterAlpha)
+	}
+	if d.MaxFailures < 1 {
+		return fmt.Errorf("maximum number of failures must be at least 1: %d", d.MaxFailures)
+	}
+	if d.FailSafeSpeed < 1 || d.FailSafeSpeed > 100 {
+		return fmt.Errorf("fail-safe speed is out of range: %d", d.FailSafeSpeed)
+	}
+	if err := d.validateHTTP(); err != nil {
+		return err
+	}
+	if d.CheckInterval <= 0 {
+		return fmt.Errorf("check interval must be positive: %s", d.CheckInterval)
+	}
+	if d.Adaptive && (d.MinInterval <= 0 || d.MinInterval > d.CheckInterval || d.MaxInterval < d.CheckInterval) {
+		return fmt.Errorf("adaptive polling requires 0 < min interval <= interval <= max interval: %s, %s, %s", d.MinInterval, d.CheckInterval, d.MaxInterval)
+	}
+	return nil
 }
 
 func (d *daemonCmd) Run(
 	logger hclog.Logger,
-	readerOptions []argononefan.ThermalReaderOption,
+	newReader readerFunc,
 	fanOptions []argononefan.FanOption,
+	reload reloadFunc,
+	socket ctlSocket,
 ) error {
 
 	d.logger = logger
+	d.loaded = &cli
 
-	d.logger.Info("Starting daemon", "thresholds", d.Thresholds.thresholds, "hysteresis", d.Hysteresis, "interval", d.CheckInterval)
-
-	d.logger.Info("Starting Prometheus metrics server", "address", d.PrometheusBind)
-
-	http.Handle("/metrics", promhttp.Handler())
-	srv := http.Server{
-		Addr: d.PrometheusBind,
-	}
-
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			d.logger.Error("Starting Prometheus metrics server", "error", err)
-		} else if err == http.ErrServerClosed {
-			d.logger.Info("Prometheus metrics server stopped")
-		}
-	}()
+	d.logger.Info("Starting daemon", "mode", d.Mode, "thresholds", d.Thresholds, "hysteresis", d.Hysteresis, "curve", d.Curve, "sensor-thresholds", sensorThresholdsString(d.SensorThresholds), "interval", d.CheckInterval, "adaptive", d.Adaptive)
 
 	d.logger.Debug("Creating thermal reader")
-	tr, err := argononefan.NewThermalReader(readerOptions...)
+	tr, err := newReader(d.filterOptions()...)
 	if err != nil {
 		return fmt.Errorf("creating thermal reader: %w", err)
 	}
@@ -77,102 +139,134 @@ func (d *daemonCmd) Run(
 	if err != nil {
 		return fmt.Errorf("connecting to fan: %w", err)
 	}
+	// The daemon sets the safety speed on exit,
+	// so the connection must be closed only afterwards.
+	defer fan.Close()
+
+	d.logger.Info("Connected to fan", "model", fan.Model())
+
+	if speed, cached, err := fan.Speed(); err != nil {
+		d.logger.Debug("Current fan speed is unknown", "reason", err)
+	} else {
+		d.logger.Info("Current fan speed", "speed", speed, "cached", cached)
+	}
+
+	notifier, err := newSDNotifier(d.logger)
+	if err != nil {
+		return err
+	}
+	defer notifier.Close()
+	if notifier.watchdog > 0 {
+		d.logger.Info("Pinging systemd watchdog", "timeout", notifier.watchdog)
+		d.checkWatchdog(notifier.watchdog)
+	}
 
-	// Set the fan speed to a safe 100% to start
-	d.logger.Info("Setting initial fan speed to 100% as a safety measure", "reason", "we don't know the current CPU temperature yet")
-	if err := fan.SetSpeed(100); err != nil {
-		fanSpeedSetFailed.Inc()
-		return fmt.Errorf("setting fan speed: %w", err)
+	reg := newRegistry()
+	s := d.settings()
+	d.controller = s.controller()
+	dmn, err := daemon.New(append([]daemon.Option{
+		daemon.WithReader(tr),
+		daemon.WithFan(fan),
+		daemon.WithLogger(d.logger),
+		daemon.WithVersion(version),
+		daemon.WithRegistry(reg),
+		daemon.WithNotifier(notifier),
+	}, s.options(d.controller)...)...)
+	if err != nil {
+		return err
 	}
-	fanSpeedSet.Inc()
 
-	// Ensure the fan speed is reset to 100% when the daemon exits
-	defer func() {
-		lastTemp, err := tr.Celsius()
+	if socket != "" {
+		l, err := listenControl(string(socket))
 		if err != nil {
-			d.logger.Error("Reading temperatur", "error", fmt.Errorf("reading temperature: %w", err))
+			return err
 		}
-		d.logger.Warn("Fan control is shutting down, setting fan to 100% speed as a safety measure", "temperature", fmt.Sprintf("%2.1f°C", lastTemp))
-		fan.SetSpeed(100)
-	}()
+		defer l.Close()
+		d.logger.Info("Listening on control socket", "path", socket)
+		go d.serveControl(l, dmn)
+	}
+
+	srv, err := d.newHTTPServer(reg, dmn)
+	if err != nil {
+		return fmt.Errorf("creating HTTP server: %w", err)
+	}
+	l, err := listen(srv)
+	if err != nil {
+		return fmt.Errorf("starting HTTP server: %w", err)
+	}
+	d.logger.Info("Starting HTTP server", "address", l.Addr(), "metrics", d.MetricsPath, "tls", d.TLSCert != "", "basic-auth", d.BasicAuthUser != "")
+	go d.serve(srv, l)
 
 	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
-	errC := d.control(signalCtx, fan, tr, d.Thresholds, d.Hysteresis)
+	var buttonC <-chan argononefan.ButtonEvent
+	if d.Button {
+		d.logger.Info("Watching power button", "chip", d.ButtonChip, "line", d.ButtonLine)
+		pb, err := argononefan.NewPowerButton(argononefan.WithGPIOChip(d.ButtonChip), argononefan.WithGPIOLine(d.ButtonLine))
+		if err != nil {
+			return fmt.Errorf("creating power button: %w", err)
+		}
+		if buttonC, err = pb.Listen(signalCtx); err != nil {
+			return fmt.Errorf("watching power button: %w", err)
+		}
+	}
+
+	hupC := make(chan os.Signal, 1)
+	signal.Notify(hupC, syscall.SIGHUP)
+	defer signal.Stop(hupC)
+
+	stopC := signalCtx.Done()
+	runC := make(chan error, 1)
+	go func() {
+		runC <- dmn.Run(signalCtx)
+	}()
 
-cmdloop:
 	for {
 		select {
-		case err := <-errC:
-			d.logger.Error("Error in control loop", "error", err)
-		case <-signalCtx.Done():
-			d.logger.Debug("Received stop signal")
-			d.logger.Debug("Shutting down Prometheus metrics server")
-			if err := srv.Shutdown(nil); err != nil {
-				d.logger.Error("shutting down Prometheus metrics server:", err)
+		case <-hupC:
+			d.logger.Info("Received SIGHUP, reloading configuration")
+			if err := d.reload(reload, dmn); err != nil {
+				d.logger.Error("Reloading configuration, keeping the current one", "error", err)
+			} else if notifier.watchdog > 0 {
+				d.checkWatchdog(notifier.watchdog)
 			}
-			// Without the label, the break would only break out of the select
-			break cmdloop
+		case <-stopC:
+			// Run resets the fan speed before it returns.
+			notifier.Stopping()
+			stopC = nil
+		case ev, ok := <-buttonC:
+			if !ok {
+				d.logger.Warn("Stopped watching power button")
+				buttonC = nil
+				continue
+			}
+			go d.handleButton(ev)
+		case err := <-runC:
+			notifier.Stopping()
+			d.logger.Debug("Shutting down HTTP server")
+			ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+			if err := srv.Shutdown(ctx); err != nil {
+				d.logger.Error("Shutting down HTTP server", "error", err)
+			}
+			cancel()
+			return err
 		}
 	}
-
-	return nil
 }
 
-func (d *daemonCmd) control(ctx context.Context, fan *argononefan.Fan, tr *argononefan.ThermalReader, config *thresholds, hysteresis float32) chan error {
+// checkWatchdog warns if the control loop might not ping the watchdog
+// of systemd in time. It pings after every reading, so the longest
+// interval between two readings must leave room for a late one.
+func (d *daemonCmd) checkWatchdog(timeout time.Duration) {
+	d.mu.Lock()
+	longest := d.CheckInterval
+	if d.Adaptive {
+		longest = d.MaxInterval
+	}
+	d.mu.Unlock()
 
-	var (
-		currentSpeed       int = -1
-		currentTemperature float32
-		once               sync.Once
-		tick               = time.NewTicker(5 * time.Second)
-		errC               = make(chan error)
-		err                error
-	)
-	go func() {
-		for {
-			select {
-			case <-tick.C:
-				if currentTemperature, err = tr.Celsius(); err != nil {
-					errC <- fmt.Errorf("reading temperature: %w", err)
-				}
-				targetSpeed := config.GetSpeed(currentTemperature)
-
-				if targetSpeed < currentSpeed {
-					targetSpeed = config.GetSpeedWithHysteresis(currentTemperature, hysteresis)
-				}
-
-				switch targetSpeed {
-
-				case currentSpeed:
-					d.logger.Debug("Temperature is still within the same threshold, no need to adjust fan speed")
-
-				default:
-					d.logger.Debug("Found threshold", "threshold", config.GetThreshold(currentTemperature), "computed fanSpeed with hystersis", config.GetSpeedWithHysteresis(currentTemperature, hysteresis))
-
-					currentSpeed = targetSpeed
-					if err = fan.SetSpeed(targetSpeed); err != nil {
-						d.logger.Error("Setting fan speed", "error", err)
-						errC <- fmt.Errorf("setting fan speed: %w", err)
-						fanSpeedSetFailed.Inc()
-						continue
-					}
-
-					fanSpeed.Set(float64(targetSpeed))
-					fanSpeedSet.Inc()
-
-					once.Do(func() {
-						d.logger.Info("Set initial fan speed based on readings", "temperature", currentTemperature, "speed", currentSpeed)
-					})
-				}
-
-			case <-ctx.Done():
-				d.logger.Debug("Received stop signal")
-				d.logger.Debug("Exiting goroutine...")
-				return
-			}
-		}
-	}()
-	return errC
+	if longest > timeout/2 {
+		d.logger.Warn("Check interval is too long for the systemd watchdog, which will restart the daemon", "interval", longest, "watchdog", timeout)
+	}
 }
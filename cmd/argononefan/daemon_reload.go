@@ -0,0 +1,266 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  daemon_reload.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/mwmahlberg/argononefan/daemon"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// controlMode selects the policy used to derive the fan speed from the temperature.
+type controlMode string
+
+const (
+	// modeThresholds uses the thresholds, curve and hysteresis.
+	modeThresholds controlMode = "thresholds"
+	// modePID holds the temperature at a target using a PID controller.
+	modePID controlMode = "pid"
+)
+
+// controlSettings are the settings of the control loop
+// which can be changed while the daemon is running.
+type controlSettings struct {
+	mode       controlMode
+	pidConfig  control.PID
+	thresholds *control.Thresholds
+	hysteresis float32
+	curve      control.Curve
+	// sensorThresholds are the thresholds of individual sensors.
+	sensorThresholds map[string]*control.Thresholds
+	interval         time.Duration
+	adaptive         bool
+	minInterval      time.Duration
+	maxInterval      time.Duration
+	maxFailures      int
+	failSafeSpeed    int
+	exitOnFailure    bool
+}
+
+func (d *daemonCmd) settings() controlSettings {
+	return controlSettings{
+		mode: d.Mode,
+		pidConfig: control.PID{
+			Setpoint: float64(d.PIDTarget),
+			Kp:       d.PIDKp,
+			Ki:       d.PIDKi,
+			Kd:       d.PIDKd,
+			MinSpin:  float64(d.PIDMinSpin),
+			Filter:   d.PIDDerivativeFilter,
+		},
+		thresholds:       d.Thresholds,
+		hysteresis:       d.Hysteresis,
+		curve:            d.Curve,
+		sensorThresholds: d.SensorThresholds,
+		interval:         d.CheckInterval,
+		adaptive:         d.Adaptive,
+		minInterval:      d.MinInterval,
+		maxInterval:      d.MaxInterval,
+		maxFailures:      d.MaxFailures,
+		failSafeSpeed:    d.FailSafeSpeed,
+		exitOnFailure:    d.ExitOnFailure,
+	}
+}
+
+// controller returns a new control.Controller for the settings.
+func (s controlSettings) controller() control.Controller {
+	if s.mode == modePID {
+		pid := s.pidConfig
+		return &pid
+	}
+	newDefault := func() control.Controller {
+		return &control.ThresholdController{
+			Thresholds: s.thresholds,
+			Hysteresis: s.hysteresis,
+			Curve:      s.curve,
+		}
+	}
+	if len(s.sensorThresholds) == 0 {
+		return newDefault()
+	}
+
+	perSensor := &control.PerSensorController{NewDefault: newDefault, Sensors: make(map[string]control.Controller)}
+	for name, th := range s.sensorThresholds {
+		perSensor.Sensors[name] = &control.ThresholdController{
+			Thresholds: th,
+			Hysteresis: s.hysteresis,
+			Curve:      s.curve,
+		}
+	}
+	return perSensor
+}
+
+// options returns the daemon.Options for the settings, using controller.
+func (s controlSettings) options(controller control.Controller) []daemon.Option {
+	var poller daemon.Poller
+	if s.adaptive {
+		poller = daemon.NewAdaptivePoller(s.minInterval, s.interval, s.maxInterval, s.thresholds, s.hysteresis)
+	}
+	return []daemon.Option{
+		daemon.WithController(controller),
+		daemon.WithInterval(s.interval),
+		daemon.WithPoller(poller),
+		daemon.WithMaxFailures(s.maxFailures),
+		daemon.WithFailSafeSpeed(s.failSafeSpeed),
+		daemon.WithExitOnFailure(s.exitOnFailure),
+	}
+}
+
+// reload parses the configuration again and hands the settings
+// which can be changed at runtime to the daemon.
+func (d *daemonCmd) reload(reload reloadFunc, dmn *daemon.Daemon) error {
+	fresh, err := reload()
+	if err != nil {
+		return fmt.Errorf("parsing configuration: %w", err)
+	}
+	next := &fresh.Daemon
+
+	// The control socket changes the settings concurrently.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changes := d.diff(next)
+	if len(changes) == 0 {
+		d.logger.Info("Configuration unchanged")
+	}
+	for _, c := range changes {
+		d.logger.Info("Configuration changed", "setting", c.setting, "old", c.old, "new", c.new)
+	}
+
+	// Compared to the configuration loaded last, so that a change is reported only once.
+	for _, setting := range restartRequired(d.loaded, fresh) {
+		d.logger.Warn("Changed setting requires a restart to take effect", "setting", setting)
+	}
+	d.loaded = fresh
+
+	current := d.settings()
+	d.Mode, d.PIDTarget, d.PIDKp, d.PIDKi, d.PIDKd = next.Mode, next.PIDTarget, next.PIDKp, next.PIDKi, next.PIDKd
+	d.PIDMinSpin, d.PIDDerivativeFilter = next.PIDMinSpin, next.PIDDerivativeFilter
+	d.Thresholds, d.Hysteresis, d.Curve, d.CheckInterval = next.Thresholds, next.Hysteresis, next.Curve, next.CheckInterval
+	d.SensorThresholds = next.SensorThresholds
+	d.Adaptive, d.MinInterval, d.MaxInterval = next.Adaptive, next.MinInterval, next.MaxInterval
+	d.MaxFailures, d.FailSafeSpeed, d.ExitOnFailure = next.MaxFailures, next.FailSafeSpeed, next.ExitOnFailure
+	return d.apply(current, dmn)
+}
+
+// apply hands the settings to the daemon after they were changed from previous.
+// d.mu must be held by the caller.
+func (d *daemonCmd) apply(previous controlSettings, dmn *daemon.Daemon) error {
+	s := d.settings()
+
+	// Keep the state of the PID controller, unless it was reconfigured.
+	if s.mode != modePID || s.mode != previous.mode || s.pidConfig != previous.pidConfig {
+		d.controller = s.controller()
+	}
+	return dmn.Reconfigure(s.options(d.controller)...)
+}
+
+type settingChange struct {
+	setting  string
+	old, new any
+}
+
+// diff returns the settings changed at runtime which differ between d and next.
+// d.mu must be held by the caller.
+func (d *daemonCmd) diff(next *daemonCmd) (changes []settingChange) {
+	if d.Mode != next.Mode {
+		changes = append(changes, settingChange{"mode", d.Mode, next.Mode})
+	}
+	if o, n := d.settings().pidConfig, next.settings().pidConfig; o != n {
+		changes = append(changes, settingChange{"pid",
+			fmt.Sprintf("target=%g kp=%g ki=%g kd=%g min-spin=%g filter=%s", o.Setpoint, o.Kp, o.Ki, o.Kd, o.MinSpin, o.Filter),
+			fmt.Sprintf("target=%g kp=%g ki=%g kd=%g min-spin=%g filter=%s", n.Setpoint, n.Kp, n.Ki, n.Kd, n.MinSpin, n.Filter),
+		})
+	}
+	if o, n := d.Thresholds.String(), next.Thresholds.String(); o != n {
+		changes = append(changes, settingChange{"thresholds", o, n})
+	}
+	if o, n := sensorThresholdsString(d.SensorThresholds), sensorThresholdsString(next.SensorThresholds); o != n {
+		changes = append(changes, settingChange{"sensor-thresholds", o, n})
+	}
+	if d.Hysteresis != next.Hysteresis {
+		changes = append(changes, settingChange{"hysteresis", d.Hysteresis, next.Hysteresis})
+	}
+	if d.Curve != next.Curve {
+		changes = append(changes, settingChange{"curve", d.Curve, next.Curve})
+	}
+	if d.CheckInterval != next.CheckInterval {
+		changes = append(changes, settingChange{"interval", d.CheckInterval, next.CheckInterval})
+	}
+	if d.Adaptive != next.Adaptive {
+		changes = append(changes, settingChange{"adaptive", d.Adaptive, next.Adaptive})
+	}
+	if d.MinInterval != next.MinInterval {
+		changes = append(changes, settingChange{"min-interval", d.MinInterval, next.MinInterval})
+	}
+	if d.MaxInterval != next.MaxInterval {
+		changes = append(changes, settingChange{"max-interval", d.MaxInterval, next.MaxInterval})
+	}
+	if d.MaxFailures != next.MaxFailures {
+		changes = append(changes, settingChange{"max-failures", d.MaxFailures, next.MaxFailures})
+	}
+	if d.FailSafeSpeed != next.FailSafeSpeed {
+		changes = append(changes, settingChange{"fail-safe-speed", d.FailSafeSpeed, next.FailSafeSpeed})
+	}
+	if d.ExitOnFailure != next.ExitOnFailure {
+		changes = append(changes, settingChange{"exit-on-failure", d.ExitOnFailure, next.ExitOnFailure})
+	}
+	return changes
+}
+
+func sensorThresholdsString(thresholds map[string]*control.Thresholds) string {
+	names := maps.Keys(thresholds)
+	slices.Sort(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + thresholds[name].String()
+	}
+	return strings.Join(pairs, " ")
+}
+
+// restartRequired returns the names of the settings which differ
+// between current and next, but can not be changed at runtime.
+func restartRequired(current, next *cliFlags) (settings []string) {
+	check := func(name string, changed bool) {
+		if changed {
+			settings = append(settings, name)
+		}
+	}
+	check("device-file", current.DeviceFile != next.DeviceFile)
+	check("bus", current.Bus != next.Bus)
+	check("model", current.Model != next.Model)
+	check("fan", current.FanRetries != next.FanRetries || current.FanVerify != next.FanVerify)
+	check("debug", current.Debug != next.Debug)
+	check("socket", current.Socket != next.Socket)
+	check("sensor", !maps.Equal(current.Sensors, next.Sensors) || !maps.Equal(current.SensorWeights, next.SensorWeights) || !maps.Equal(current.SensorUnits, next.SensorUnits) || current.Aggregate != next.Aggregate)
+
+	cd, nd := &current.Daemon, &next.Daemon
+	check("filter", cd.Filter != nd.Filter || cd.FilterWindow != nd.FilterWindow || cd.FilterAlpha != nd.FilterAlpha)
+	check("http", cd.PrometheusBind != nd.PrometheusBind || cd.MetricsPath != nd.MetricsPath || cd.ReadyIntervals != nd.ReadyIntervals ||
+		cd.TLSCert != nd.TLSCert || cd.TLSKey != nd.TLSKey || cd.BasicAuthUser != nd.BasicAuthUser || cd.BasicAuthPasswordFile != nd.BasicAuthPasswordFile)
+	check("button", cd.Button != nd.Button || cd.ButtonChip != nd.ButtonChip || cd.ButtonLine != nd.ButtonLine ||
+		cd.ButtonDoubleTap != nd.ButtonDoubleTap || cd.ButtonLongPress != nd.ButtonLongPress)
+	return settings
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSettings returns the settings of a daemon for reload tests.
+func testSettings() daemonCmd {
+	return daemonCmd{
+		Mode:          modeThresholds,
+		Thresholds:    control.NewThresholds(map[float32]int{70: 100, 60: 50}),
+		CheckInterval: time.Millisecond,
+		MaxFailures:   3,
+		FailSafeSpeed: 100,
+	}
+}
+
+func TestReloadRestartRequired(t *testing.T) {
+	var out bytes.Buffer
+	d := testSettings()
+	d.logger = hclog.New(&hclog.LoggerOptions{Output: &out})
+	d.loaded = &cliFlags{Bus: 1, Daemon: testSettings()}
+	dmn := startDaemon(t, &d)
+
+	reload := func() (*cliFlags, error) {
+		return &cliFlags{Bus: 2, Daemon: testSettings()}, nil
+	}
+	require.NoError(t, d.reload(reload, dmn))
+	require.NoError(t, d.reload(reload, dmn))
+	assert.Equal(t, 1, strings.Count(out.String(), "requires a restart"), "a change must be reported only once")
+}
+
+func TestReloadWhileSettingThresholds(t *testing.T) {
+	d := testSettings()
+	d.logger = hclog.NewNullLogger()
+	d.loaded = &cliFlags{Daemon: testSettings()}
+	dmn := startDaemon(t, &d)
+
+	reload := func() (*cliFlags, error) {
+		return &cliFlags{Daemon: testSettings()}, nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, d.reload(reload, dmn))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			resp := d.handleControl(ctlRequest{Command: ctlSetThresholds, Thresholds: "65=80"}, dmn)
+			assert.Empty(t, resp.Error)
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  getspeed_cmd.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+)
+
+type getSpeedCmd struct{}
+
+func (c *getSpeedCmd) Run(logger hclog.Logger, fanOptions []argononefan.FanOption) error {
+
+	ml := logger.Named("get-speed")
+	ml.Debug("Connecting to fan")
+
+	fan, err := argononefan.Connect(fanOptions...)
+	if err != nil {
+		return fmt.Errorf("error connecting to fan: %w", err)
+	}
+	defer fan.Close()
+
+	speed, cached, err := fan.Speed()
+	if errors.Is(err, argononefan.ErrSpeedUnknown) {
+		return errors.New("fan speed is unknown: the controller does not support reading it back")
+	} else if err != nil {
+		return fmt.Errorf("reading fan speed: %w", err)
+	}
+
+	ml.Debug("Read fan speed", "model", fan.Model(), "speed", speed, "cached", cached)
+	_, werr := fmt.Printf("Fan speed: %d%%\n", speed)
+	return werr
+}
@@ -29,3 +29,23 @@ Note that this only applies to the fan slowing down coming from a higher
 threshold, not when speeding up.
 `
 const thresholdsHelp = `thresholds is map of °C to fan speed in %`
+
+const curveHelp = `How the fan speed is derived from the thresholds.
+
+step uses the speed of the highest threshold reached. linear and spline
+interpolate between the thresholds, linearly or along a smooth curve.
+In all modes, the fan is off below the lowest threshold.
+`
+
+const buttonActionHelp = `Action to run on the power button event: reboot, poweroff, none or a command run by /bin/sh`
+
+const sensorHelp = `Named temperature sensor to read instead of --device-file, for example
+nvme=/sys/class/hwmon/hwmon1/temp1_input. May be repeated. The readings of all
+sensors are combined as set by --aggregate.
+`
+
+const sensorThresholdsHelp = `Thresholds of a single sensor in the form NAME=THRESHOLDS, for example
+nvme=55=100;45=30. May be repeated. If set, each sensor is checked against its
+own thresholds or, if it has none, against --thresholds, and the highest fan
+speed demanded wins.
+`
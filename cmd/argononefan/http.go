@@ -0,0 +1,171 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  http.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	statusPath  = "/status"
+)
+
+// readinessChecker is implemented by daemon.Daemon.
+type readinessChecker interface {
+	Ready(intervals int) error
+}
+
+// daemonState is implemented by daemon.Daemon.
+type daemonState interface {
+	readinessChecker
+	statusReporter
+}
+
+// validateHTTP checks the settings of the HTTP server.
+func (d *daemonCmd) validateHTTP() error {
+	switch {
+	case !strings.HasPrefix(d.MetricsPath, "/"):
+		return fmt.Errorf("metrics path must start with '/': %s", d.MetricsPath)
+	case d.MetricsPath == healthzPath || d.MetricsPath == readyzPath || d.MetricsPath == statusPath:
+		return fmt.Errorf("metrics path must differ from %s, %s and %s: %s", healthzPath, readyzPath, statusPath, d.MetricsPath)
+	case d.ReadyIntervals < 1:
+		return fmt.Errorf("ready intervals must be at least 1: %d", d.ReadyIntervals)
+	case (d.TLSCert == "") != (d.TLSKey == ""):
+		return errors.New("TLS requires both a certificate and a key")
+	case (d.BasicAuthUser == "") != (d.BasicAuthPasswordFile == ""):
+		return errors.New("basic auth requires both a user and a password file")
+	}
+	return nil
+}
+
+// newRegistry returns the registry for the metrics of the daemon,
+// including those of the Go runtime and the process.
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// newHTTPServer returns the server for the metrics in reg and the status,
+// health and readiness of dmn. Only the metrics and the status are protected
+// by basic auth, if configured, so that probes do not need credentials.
+func (d *daemonCmd) newHTTPServer(reg *prometheus.Registry, dmn daemonState) (*http.Server, error) {
+	var metrics http.Handler = promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+	var status http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d.status(dmn)); err != nil {
+			d.logger.Error("Writing status", "error", err)
+		}
+	})
+	if d.BasicAuthUser != "" {
+		password, err := os.ReadFile(d.BasicAuthPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading basic auth password: %w", err)
+		}
+		p := strings.TrimRight(string(password), "\r\n")
+		metrics = basicAuth(metrics, d.BasicAuthUser, p)
+		status = basicAuth(status, d.BasicAuthUser, p)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(d.MetricsPath, metrics)
+	mux.Handle(statusPath, status)
+	mux.HandleFunc(healthzPath, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc(readyzPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := dmn.Ready(d.ReadyIntervals); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	srv := &http.Server{Addr: d.PrometheusBind, Handler: mux}
+	if d.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(d.TLSCert, d.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	return srv, nil
+}
+
+// basicAuth requires the requests to next to authenticate as user with password.
+func basicAuth(next http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		// Both are compared, so that the time taken does not tell which was wrong.
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="argononefan"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listen opens the listener for srv, so that the daemon does not start
+// if the probes can not reach it.
+func listen(srv *http.Server) (net.Listener, error) {
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", srv.Addr, err)
+	}
+	return l, nil
+}
+
+// serve runs srv on l until it is shut down, with TLS if configured.
+func (d *daemonCmd) serve(srv *http.Server, l net.Listener) {
+	var err error
+	if srv.TLSConfig != nil {
+		// The certificate was loaded into the TLSConfig by newHTTPServer.
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		err = srv.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		d.logger.Info("HTTP server stopped")
+		return
+	}
+	d.logger.Error("Running HTTP server", "error", err)
+}
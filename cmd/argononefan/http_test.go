@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/mwmahlberg/argononefan/daemon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDaemon struct {
+	ready  func(intervals int) error
+	status daemon.Status
+}
+
+func (f *fakeDaemon) Ready(intervals int) error {
+	return f.ready(intervals)
+}
+
+func (f *fakeDaemon) Status() daemon.Status {
+	return f.status
+}
+
+func TestHTTPServer(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	var readyErr error
+	d := &daemonCmd{
+		Mode:                  modeThresholds,
+		Thresholds:            control.NewThresholds(map[float32]int{70: 100, 60: 50}),
+		Curve:                 control.CurveLinear,
+		CheckInterval:         time.Second,
+		Filter:                filterEMA,
+		FilterAlpha:           0.5,
+		MetricsPath:           "/custom",
+		ReadyIntervals:        3,
+		BasicAuthUser:         "prometheus",
+		BasicAuthPasswordFile: passwordFile,
+		logger:                hclog.NewNullLogger(),
+	}
+	require.NoError(t, d.validateHTTP())
+	started := time.Now().Add(-time.Hour)
+	srv, err := d.newHTTPServer(newRegistry(), &fakeDaemon{
+		ready: func(intervals int) error {
+			assert.Equal(t, 3, intervals)
+			return readyErr
+		},
+		status: daemon.Status{
+			Temperature:   argononefan.FromCelsius(62),
+			Sensors:       map[string]float32{"cpu": 62, "nvme": 48},
+			Speed:         70,
+			TargetSpeed:   70,
+			Control:       control.State{Threshold: 60, Active: true, Holding: true},
+			LastError:     errors.New("sensor unplugged"),
+			LastErrorTime: started,
+			Started:       started,
+		},
+	})
+	require.NoError(t, err)
+
+	serve := func(path string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth {
+			req.SetBasicAuth("prometheus", "secret")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(path string, auth bool) int {
+		return serve(path, auth).Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/custom", false))
+	assert.Equal(t, http.StatusOK, get("/custom", true))
+	assert.Equal(t, http.StatusNotFound, get("/metrics", true))
+	assert.Equal(t, http.StatusOK, get(healthzPath, false), "probes must not need credentials")
+	assert.Equal(t, http.StatusOK, get(readyzPath, false))
+	readyErr = errors.New("temperature not read yet")
+	assert.Equal(t, http.StatusServiceUnavailable, get(readyzPath, false))
+
+	assert.Equal(t, http.StatusUnauthorized, get(statusPath, false))
+	rec := serve(statusPath, true)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var status daemonStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, map[string]float32{"cpu": 62, "nvme": 48}, status.Sensors)
+	assert.Equal(t, 70, status.Speed)
+	require.NotNil(t, status.ActiveThreshold)
+	assert.Equal(t, float32(60), *status.ActiveThreshold)
+	assert.True(t, status.HysteresisHolding)
+	require.NotNil(t, status.LastError)
+	assert.Equal(t, "sensor unplugged", status.LastError.Message)
+	assert.Equal(t, "1h0m0s", status.Uptime)
+	assert.Equal(t, daemonConfig{
+		Mode:        modeThresholds,
+		Thresholds:  "70=100;60=50",
+		Curve:       control.CurveLinear,
+		Interval:    "1s",
+		Filter:      filterEMA,
+		FilterAlpha: 0.5,
+	}, status.Config)
+
+	d.MetricsPath = statusPath
+	assert.Error(t, d.validateHTTP(), "the metrics must not shadow the status")
+	d.MetricsPath = "/custom"
+
+	d.TLSCert = "cert.pem"
+	assert.Error(t, d.validateHTTP(), "a certificate requires a key")
+}
+
+func TestHTTPServerStartup(t *testing.T) {
+	d := &daemonCmd{
+		PrometheusBind: "127.0.0.1:0",
+		MetricsPath:    "/metrics",
+		ReadyIntervals: 3,
+		logger:         hclog.NewNullLogger(),
+	}
+	srv, err := d.newHTTPServer(newRegistry(), &fakeDaemon{})
+	require.NoError(t, err)
+	l, err := listen(srv)
+	require.NoError(t, err)
+	go d.serve(srv, l)
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + l.Addr().String() + healthzPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	d.PrometheusBind = l.Addr().String()
+	srv, err = d.newHTTPServer(newRegistry(), &fakeDaemon{})
+	require.NoError(t, err)
+	_, err = listen(srv)
+	assert.Error(t, err, "a port in use must fail startup")
+
+	d.TLSCert = filepath.Join(t.TempDir(), "cert.pem")
+	d.TLSKey = filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(d.TLSCert, []byte("not a certificate"), 0o600))
+	_, err = d.newHTTPServer(newRegistry(), &fakeDaemon{})
+	assert.ErrorContains(t, err, "loading TLS certificate", "a bad certificate must fail startup")
+}
@@ -21,10 +21,12 @@ package main
 
 import (
 	"os"
+	"reflect"
 
 	"github.com/alecthomas/kong"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
 )
 
 const (
@@ -36,29 +38,94 @@ var (
 	version = "dev"
 )
 
-var cli struct {
-	Debug      bool   `short:"d" long:"debug" help:"Enable debug mode" default:"false"`
-	DeviceFile string `short:"f" long:"file" help:"File path in sysfs containing current CPU temperature" default:"/sys/class/thermal/thermal_zone0/temp"`
-	Bus        int    `short:"b" long:"bus" help:"I2C bus the fan resides on" default:"0"`
+type cliFlags struct {
+	Config        string                  `short:"c" long:"config" help:"YAML config file. Flags and environment variables take precedence over its values" type:"path"`
+	Debug         bool                    `short:"d" long:"debug" help:"Enable debug mode" default:"false"`
+	DeviceFile    string                  `short:"f" long:"file" help:"File path in sysfs containing current CPU temperature" default:"/sys/class/thermal/thermal_zone0/temp"`
+	Sensors       map[string]string       `name:"sensor" help:"${help_sensor}" mapsep:"none" placeholder:"NAME=PATH"`
+	SensorWeights map[string]float64      `name:"sensor-weight" help:"Weight of a sensor for --aggregate=average, 1 by default" placeholder:"NAME=WEIGHT"`
+	SensorUnits   map[string]string       `name:"sensor-unit" help:"Unit of the readings of a sensor: millicelsius (sysfs, the default) or celsius (plain degrees)" placeholder:"NAME=UNIT"`
+	Aggregate     argononefan.Aggregation `long:"aggregate" help:"How the readings of multiple sensors are combined: max or average" enum:"max,average" default:"max"`
+	Bus           int                     `short:"b" long:"bus" help:"I2C bus the fan resides on" default:"0"`
+	Model         argononefan.Model       `short:"m" long:"model" help:"Model of the ArgonOne case: v2 (Pi 4), v3 (Pi 5) or auto to determine it from the model of the Pi" default:"v2"`
+	FanRetries    int                     `name:"fan-retries" help:"Number of times setting the fan speed is retried after an I2C error" default:"2"`
+	FanVerify     bool                    `name:"fan-verify" help:"Read the fan speed back after setting it and retry if it differs, if the model supports it" default:"false"`
+
+	Socket string `name:"socket" help:"Control socket of the daemon, empty to disable it" default:"/run/argononefan/control.sock"`
+
+	Daemon       daemonCmd        `kong:"cmd,help='Run the fan control daemon'"`
+	Ctl          ctlCmd           `kong:"cmd,help='Control a running daemon'"`
+	Temperature  temperatureCmd   `kong:"cmd,help='Read the current CPU temperature'"`
+	SetSpeed     setSpeedCmd      `kong:"cmd,help='Set the fan speed manually. A running daemon changes it again, use ctl override instead'"`
+	GetSpeed     getSpeedCmd      `kong:"cmd,help='Read the current fan speed'"`
+	ListSensors  sensorsCmd       `kong:"cmd,name='sensors',help='List the temperature sensors available'"`
+	PoweroffHook poweroffHookCmd  `kong:"cmd,help='Signal the case to cut power after halt, for use by systemd-shutdown'"`
+	Version      kong.VersionFlag `env:"-"`
+}
+
+var cli cliFlags
 
-	Daemon      daemonCmd        `kong:"cmd,help='Run the fan control daemon'"`
-	Temperature temperatureCmd   `kong:"cmd,help='Read the current CPU temperature'"`
-	SetSpeed    setSpeedCmd      `kong:"cmd,help='Set the fan speed manually'"`
-	Version     kong.VersionFlag `env:"-"`
+// BeforeResolve loads the config file, before kong resolves
+// the values of flags not set on the command line.
+func (c *cliFlags) BeforeResolve(ctx *kong.Context, r *configResolver) error {
+	return r.load(ctx)
 }
 
-func main() {
+// reloadFunc parses the command line, environment and config file again.
+// It is used by the daemon to reload its configuration.
+type reloadFunc func() (*cliFlags, error)
+
+// thresholdsMapper decodes *control.Thresholds into a newly allocated value,
+// as kong would call UnmarshalText on a nil pointer for values of maps.
+var thresholdsMapper = kong.MapperFunc(func(ctx *kong.DecodeContext, target reflect.Value) error {
+	var text string
+	if err := ctx.Scan.PopValueInto("thresholds", &text); err != nil {
+		return err
+	}
+	t := &control.Thresholds{}
+	if err := t.UnmarshalText([]byte(text)); err != nil {
+		return err
+	}
+	target.Set(reflect.ValueOf(t))
+	return nil
+})
 
-	ctx := kong.Parse(&cli,
+func parserOptions() []kong.Option {
+	resolver := &configResolver{}
+	return []kong.Option{
 		kong.Name("argononefan"),
 		kong.Description("Tools for fan control of the ArgonOne case"),
 		kong.DefaultEnvars("ARGONONEFAN"),
+		kong.Resolvers(resolver),
+		kong.Bind(resolver),
+		kong.TypeMapper(reflect.TypeOf(&control.Thresholds{}), thresholdsMapper),
 		kong.Vars{
-			"version":         version,
-			"help_hysteresis": hystereisHelp,
-			"help_thresholds": thresholdsHelp,
+			"version":                version,
+			"help_hysteresis":        hystereisHelp,
+			"help_thresholds":        thresholdsHelp,
+			"help_curve":             curveHelp,
+			"help_sensor":            sensorHelp,
+			"help_sensor_thresholds": sensorThresholdsHelp,
+			"help_button_action":     buttonActionHelp,
 		},
-	)
+	}
+}
+
+func reload() (*cliFlags, error) {
+	fresh := &cliFlags{}
+	parser, err := kong.New(fresh, parserOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parser.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+func main() {
+
+	ctx := kong.Parse(&cli, parserOptions()...)
 	ctx.Stderr = os.Stdout
 
 	var level hclog.Level = hclog.Info
@@ -83,8 +150,15 @@ func main() {
 	// but only concrete types, of which it will determine the
 	// reflection type and then bind to that.
 	ctx.BindTo(l, (*hclog.Logger)(nil))
-	ctx.Bind([]argononefan.ThermalReaderOption{argononefan.WithThermalDeviceFile(cli.DeviceFile)})
-	ctx.Bind([]argononefan.FanOption{argononefan.OnBus(cli.Bus)})
+	ctx.Bind(readerFunc(cli.newReader))
+	ctx.Bind([]argononefan.FanOption{
+		argononefan.OnBus(cli.Bus),
+		argononefan.WithModel(cli.Model),
+		argononefan.WithRetries(cli.FanRetries),
+		argononefan.WithVerify(cli.FanVerify),
+	})
+	ctx.Bind(reloadFunc(reload))
+	ctx.Bind(ctlSocket(cli.Socket))
 
 	ctx.FatalIfErrorf(ctx.Run())
 
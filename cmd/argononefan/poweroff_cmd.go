@@ -0,0 +1,54 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  poweroff_cmd.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+)
+
+// poweroffHookCmd is meant to be run by systemd-shutdown,
+// which passes the kind of shutdown as the only argument.
+type poweroffHookCmd struct {
+	Action string `arg:"" optional:"" help:"Kind of shutdown as passed by systemd-shutdown. The signal is only sent for poweroff and halt" default:"poweroff" env:"-"`
+}
+
+func (c *poweroffHookCmd) Run(logger hclog.Logger, fanOptions []argononefan.FanOption) error {
+
+	ml := logger.Named("poweroff-hook")
+
+	switch c.Action {
+	case "poweroff", "halt":
+	default:
+		ml.Debug("Not signalling power off", "action", c.Action)
+		return nil
+	}
+
+	fan, err := argononefan.Connect(fanOptions...)
+	if err != nil {
+		return fmt.Errorf("error connecting to fan: %w", err)
+	}
+	defer fan.Close()
+
+	ml.Info("Signalling the case to cut power", "model", fan.Model())
+	return fan.SignalPowerOff()
+}
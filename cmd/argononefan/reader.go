@@ -0,0 +1,126 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  reader.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mwmahlberg/argononefan"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// temperatureReader is implemented by argononefan.ThermalReader
+// and argononefan.MultiReader.
+type temperatureReader interface {
+	Celsius() (float32, error)
+	Read(ctx context.Context) (argononefan.Reading, error)
+}
+
+// readerFunc creates the temperatureReader configured on the command line.
+// opts are applied to the reader of each sensor.
+type readerFunc func(opts ...argononefan.ThermalReaderOption) (temperatureReader, error)
+
+// newReader returns a reader for the device file or,
+// if sensors are configured, a reader combining them.
+func (c *cliFlags) newReader(opts ...argononefan.ThermalReaderOption) (temperatureReader, error) {
+	if len(c.Sensors) == 0 {
+		return argononefan.NewThermalReader(append([]argononefan.ThermalReaderOption{argononefan.WithThermalDeviceFile(c.DeviceFile)}, opts...)...)
+	}
+
+	mrOpts := []argononefan.MultiReaderOption{argononefan.WithAggregation(c.Aggregate)}
+	// Sorted, so that errors are reported in a stable order.
+	names := maps.Keys(c.Sensors)
+	slices.Sort(names)
+	for _, name := range names {
+		trOpts := []argononefan.ThermalReaderOption{
+			argononefan.WithThermalDeviceFile(c.Sensors[name]),
+			argononefan.WithUnit(sensorUnits[c.SensorUnits[name]]),
+		}
+		tr, err := argononefan.NewThermalReader(append(trOpts, opts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("sensor '%s': %w", name, err)
+		}
+		weight, ok := c.SensorWeights[name]
+		if !ok {
+			weight = 1
+		}
+		mrOpts = append(mrOpts, argononefan.WithSensor(name, tr, weight))
+	}
+	return argononefan.NewMultiReader(mrOpts...)
+}
+
+// sensorUnits maps the names of units on the command line to argononefan.Units.
+var sensorUnits = map[string]argononefan.Unit{
+	"":             argononefan.UnitMilliCelsius,
+	"millicelsius": argononefan.UnitMilliCelsius,
+	"celsius":      argononefan.UnitCelsius,
+}
+
+// Validate checks the fan settings and that weights, units and thresholds
+// are only set for configured sensors.
+func (c *cliFlags) Validate() error {
+	if c.FanRetries < 0 {
+		return fmt.Errorf("number of fan retries must not be negative: %d", c.FanRetries)
+	}
+	for name := range c.SensorWeights {
+		if _, ok := c.Sensors[name]; !ok {
+			return fmt.Errorf("weight set for unknown sensor '%s'", name)
+		}
+	}
+	for name, unit := range c.SensorUnits {
+		if _, ok := c.Sensors[name]; !ok {
+			return fmt.Errorf("unit set for unknown sensor '%s'", name)
+		}
+		if _, ok := sensorUnits[unit]; !ok || unit == "" {
+			return fmt.Errorf("unknown unit '%s' of sensor '%s': must be one of millicelsius or celsius", unit, name)
+		}
+	}
+	for name := range c.Daemon.SensorThresholds {
+		if _, ok := c.Sensors[name]; !ok {
+			return fmt.Errorf("thresholds set for unknown sensor '%s'", name)
+		}
+	}
+	return nil
+}
+
+// filterKind is the smoothing applied to the temperature readings.
+type filterKind string
+
+const (
+	filterNone    filterKind = "none"
+	filterAverage filterKind = "average"
+	filterEMA     filterKind = "ema"
+	filterMedian  filterKind = "median"
+)
+
+// filterOptions returns the options for the smoothing configured.
+func (d *daemonCmd) filterOptions() []argononefan.ThermalReaderOption {
+	switch d.Filter {
+	case filterAverage:
+		return []argononefan.ThermalReaderOption{argononefan.WithMovingAverage(d.FilterWindow)}
+	case filterEMA:
+		return []argononefan.ThermalReaderOption{argononefan.WithExponentialMovingAverage(d.FilterAlpha)}
+	case filterMedian:
+		return []argononefan.ThermalReaderOption{argononefan.WithMedianFilter(d.FilterWindow)}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  sdnotify.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan/daemon"
+)
+
+// The environment variables systemd passes to services, see sd_notify(3) and sd_watchdog_enabled(3).
+const (
+	envNotifySocket = "NOTIFY_SOCKET"
+	envWatchdogUsec = "WATCHDOG_USEC"
+	envWatchdogPID  = "WATCHDOG_PID"
+)
+
+// sdNotifier reports the state of the daemon to systemd.
+// Without a notification socket, it does nothing.
+type sdNotifier struct {
+	logger hclog.Logger
+	// conn is nil if the daemon was not started by systemd with Type=notify.
+	conn *net.UnixConn
+	// watchdog is the watchdog timeout, zero if the watchdog is disabled.
+	watchdog time.Duration
+	ready    sync.Once
+	stopping sync.Once
+}
+
+// newSDNotifier connects to the notification socket of systemd, if any.
+// The environment variables are unset, so that they are not passed on
+// to the commands run for the power button.
+func newSDNotifier(logger hclog.Logger) (*sdNotifier, error) {
+	n := &sdNotifier{logger: logger}
+	defer os.Unsetenv(envNotifySocket)
+	defer os.Unsetenv(envWatchdogUsec)
+	defer os.Unsetenv(envWatchdogPID)
+
+	path := os.Getenv(envNotifySocket)
+	if path == "" {
+		return n, nil
+	}
+	// An abstract socket name starts with @, which net translates.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to systemd notification socket: %w", err)
+	}
+	n.conn = conn
+
+	if pid := os.Getenv(envWatchdogPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// The watchdog is meant for another process.
+		return n, nil
+	}
+	if usec := os.Getenv(envWatchdogUsec); usec != "" {
+		v, err := strconv.ParseUint(usec, 10, 64)
+		if err != nil || v == 0 {
+			conn.Close()
+			return nil, fmt.Errorf("invalid %s: %s", envWatchdogUsec, usec)
+		}
+		n.watchdog = time.Duration(v) * time.Microsecond
+	}
+	return n, nil
+}
+
+// Notify implements daemon.Notifier. The first healthy iteration makes
+// the daemon ready, every healthy iteration keeps the watchdog from firing.
+func (n *sdNotifier) Notify(st daemon.Status, healthy bool) {
+	if n.conn == nil {
+		return
+	}
+	if !healthy {
+		n.send(fmt.Sprintf("STATUS=Failing at %2.1f°C, fan at %d%%: %s", st.Temperature.Celsius(), st.Speed, st.LastError))
+		return
+	}
+
+	states := []string{fmt.Sprintf("STATUS=%2.1f°C, fan at %d%%", st.Temperature.Celsius(), st.Speed)}
+	n.ready.Do(func() {
+		states = append(states, "READY=1")
+	})
+	if n.watchdog > 0 {
+		states = append(states, "WATCHDOG=1")
+	}
+	n.send(states...)
+}
+
+// Stopping tells systemd the daemon is shutting down. It only does so once.
+func (n *sdNotifier) Stopping() {
+	if n.conn == nil {
+		return
+	}
+	n.stopping.Do(func() {
+		n.send("STOPPING=1", "STATUS=Shutting down")
+	})
+}
+
+// Close closes the connection to systemd.
+func (n *sdNotifier) Close() error {
+	if n.conn == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
+
+func (n *sdNotifier) send(states ...string) {
+	if _, err := n.conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		n.logger.Error("Notifying systemd", "error", err)
+	}
+}
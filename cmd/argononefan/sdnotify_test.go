@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/daemon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSDNotifier(t *testing.T) {
+	t.Setenv(envNotifySocket, "")
+	n, err := newSDNotifier(hclog.NewNullLogger())
+	require.NoError(t, err)
+	n.Notify(daemon.Status{}, true)
+	n.Stopping()
+	assert.NoError(t, n.Close(), "without systemd, notifying must do nothing")
+
+	path := filepath.Join(t.TempDir(), "notify")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer l.Close()
+	receive := func() string {
+		buf := make([]byte, 1024)
+		l.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := l.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	t.Setenv(envNotifySocket, path)
+	t.Setenv(envWatchdogUsec, "10000000")
+	t.Setenv(envWatchdogPID, strconv.Itoa(os.Getpid()))
+	n, err = newSDNotifier(hclog.NewNullLogger())
+	require.NoError(t, err)
+	defer n.Close()
+	assert.Equal(t, 10*time.Second, n.watchdog)
+	_, set := os.LookupEnv(envNotifySocket)
+	assert.False(t, set, "the socket must not be passed on to child processes")
+
+	st := daemon.Status{Temperature: argononefan.FromCelsius(52.5), Speed: 10}
+	n.Notify(st, true)
+	assert.Equal(t, "STATUS=52.5°C, fan at 10%\nREADY=1\nWATCHDOG=1", receive())
+	n.Notify(st, true)
+	assert.Equal(t, "STATUS=52.5°C, fan at 10%\nWATCHDOG=1", receive(), "the daemon must be ready only once")
+
+	st.LastError = errors.New("sensor unplugged")
+	n.Notify(st, false)
+	assert.Equal(t, "STATUS=Failing at 52.5°C, fan at 10%: sensor unplugged", receive(), "the watchdog must not be pinged while failing")
+
+	n.Stopping()
+	n.Stopping()
+	assert.Equal(t, "STOPPING=1\nSTATUS=Shutting down", receive())
+	l.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = l.Read(make([]byte, 1024))
+	assert.Error(t, err, "stopping must be sent only once")
+
+	t.Setenv(envNotifySocket, path)
+	t.Setenv(envWatchdogUsec, "10000000")
+	t.Setenv(envWatchdogPID, "1")
+	other, err := newSDNotifier(hclog.NewNullLogger())
+	require.NoError(t, err)
+	defer other.Close()
+	assert.Zero(t, other.watchdog, "the watchdog of another process must be ignored")
+}
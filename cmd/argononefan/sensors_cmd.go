@@ -0,0 +1,61 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  sensors_cmd.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+)
+
+type sensorsCmd struct {
+	SysfsRoot string `long:"sysfs-root" help:"Directory sysfs is mounted at" default:"/sys" type:"path"`
+}
+
+func (sc *sensorsCmd) Run(logger hclog.Logger) error {
+	ml := logger.Named("sensors")
+	ml.Debug("Discovering sensors", "root", sc.SysfsRoot)
+
+	sensors, err := argononefan.DiscoverSensors(argononefan.WithSysfsRoot(sc.SysfsRoot))
+	if err != nil {
+		return err
+	}
+	if len(sensors) == 0 {
+		return fmt.Errorf("no sensors found in %s", sc.SysfsRoot)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tLABEL\tTEMPERATURE\tPATH")
+	for _, s := range sensors {
+		reading := "n/a"
+		if tr, err := argononefan.NewThermalReader(argononefan.WithThermalDeviceFile(s.Path)); err != nil {
+			ml.Debug("Creating thermal reader", "sensor", s.ID, "error", err)
+		} else if t, err := tr.Celsius(); err != nil {
+			ml.Debug("Reading temperature", "sensor", s.ID, "error", err)
+		} else {
+			reading = fmt.Sprintf("%2.1f°C", t)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.Type, s.Label, reading, s.Path)
+	}
+	return w.Flush()
+}
@@ -35,5 +35,7 @@ func (c *setSpeedCmd) Run(fanOptions []argononefan.FanOption) error {
 	if err != nil {
 		return fmt.Errorf("error connecting to fan: %w", err)
 	}
+	defer fan.Close()
+
 	return fan.SetSpeed(c.Speed)
 }
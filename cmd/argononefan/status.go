@@ -0,0 +1,185 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  status.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/mwmahlberg/argononefan/daemon"
+)
+
+// The status of the daemon is served on the control socket and over HTTP.
+
+// statusReporter is implemented by daemon.Daemon.
+type statusReporter interface {
+	Status() daemon.Status
+}
+
+type daemonStatus struct {
+	Version string `json:"version"`
+	// Started is nil if the control loop did not start yet.
+	Started *time.Time `json:"started,omitempty"`
+	Uptime  string     `json:"uptime,omitempty"`
+	// Temperature in °C.
+	Temperature float32 `json:"temperature"`
+	// Sensors are the temperatures in °C of the individual sensors by name.
+	Sensors map[string]float32 `json:"sensors,omitempty"`
+	// LastReading is nil if the temperature was not read yet.
+	LastReading *time.Time `json:"last_reading,omitempty"`
+	Speed       int        `json:"speed"`
+	TargetSpeed int        `json:"target_speed"`
+	// ActiveThreshold is the threshold in °C in effect,
+	// nil below all thresholds and in PID mode.
+	ActiveThreshold   *float32        `json:"active_threshold,omitempty"`
+	HysteresisHolding bool            `json:"hysteresis_holding"`
+	Interval          string          `json:"interval"`
+	Failures          int             `json:"failures"`
+	FailSafe          bool            `json:"fail_safe"`
+	Paused            bool            `json:"paused"`
+	Override          *overrideStatus `json:"override,omitempty"`
+	LastError         *errorStatus    `json:"last_error,omitempty"`
+	Config            daemonConfig    `json:"config"`
+}
+
+type overrideStatus struct {
+	Speed int `json:"speed"`
+	// Until is nil if the override does not expire.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+type errorStatus struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// daemonConfig is the configuration the daemon runs with,
+// including the changes made by reloads and the control socket.
+type daemonConfig struct {
+	Mode             controlMode       `json:"mode"`
+	Thresholds       string            `json:"thresholds"`
+	Hysteresis       float32           `json:"hysteresis"`
+	Curve            control.Curve     `json:"curve"`
+	SensorThresholds map[string]string `json:"sensor_thresholds,omitempty"`
+	// PID is nil unless the mode is pid.
+	PID           *pidConfig `json:"pid,omitempty"`
+	Interval      string     `json:"interval"`
+	Adaptive      bool       `json:"adaptive"`
+	MinInterval   string     `json:"min_interval,omitempty"`
+	MaxInterval   string     `json:"max_interval,omitempty"`
+	Filter        filterKind `json:"filter"`
+	FilterWindow  int        `json:"filter_window,omitempty"`
+	FilterAlpha   float32    `json:"filter_alpha,omitempty"`
+	MaxFailures   int        `json:"max_failures"`
+	FailSafeSpeed int        `json:"fail_safe_speed"`
+	ExitOnFailure bool       `json:"exit_on_failure"`
+}
+
+type pidConfig struct {
+	Target           float32 `json:"target"`
+	Kp               float64 `json:"kp"`
+	Ki               float64 `json:"ki"`
+	Kd               float64 `json:"kd"`
+	MinSpin          int     `json:"min_spin"`
+	DerivativeFilter string  `json:"derivative_filter"`
+}
+
+// status returns the status of dmn along with the configuration of d.
+func (d *daemonCmd) status(dmn statusReporter) *daemonStatus {
+	st := dmn.Status()
+	s := &daemonStatus{
+		Version:           version,
+		Temperature:       st.Temperature.Celsius(),
+		Sensors:           st.Sensors,
+		Speed:             st.Speed,
+		TargetSpeed:       st.TargetSpeed,
+		HysteresisHolding: st.Control.Holding,
+		Interval:          st.Interval.String(),
+		Failures:          st.Failures,
+		FailSafe:          st.FailSafe,
+		Paused:            st.Paused,
+	}
+	if !st.Started.IsZero() {
+		s.Started = &st.Started
+		s.Uptime = time.Since(st.Started).Round(time.Second).String()
+	}
+	if !st.LastReading.IsZero() {
+		s.LastReading = &st.LastReading
+	}
+	if st.Control.Active {
+		s.ActiveThreshold = &st.Control.Threshold
+	}
+	if o := st.Override; o != nil {
+		s.Override = &overrideStatus{Speed: o.Speed}
+		if !o.Until.IsZero() {
+			s.Override.Until = &o.Until
+		}
+	}
+	if st.LastError != nil {
+		s.LastError = &errorStatus{Message: st.LastError.Error(), Time: st.LastErrorTime}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s.Config = d.config()
+	return s
+}
+
+// config returns the configuration of d. d.mu must be held.
+func (d *daemonCmd) config() daemonConfig {
+	c := daemonConfig{
+		Mode:          d.Mode,
+		Thresholds:    d.Thresholds.String(),
+		Hysteresis:    d.Hysteresis,
+		Curve:         d.Curve,
+		Interval:      d.CheckInterval.String(),
+		Adaptive:      d.Adaptive,
+		Filter:        d.Filter,
+		MaxFailures:   d.MaxFailures,
+		FailSafeSpeed: d.FailSafeSpeed,
+		ExitOnFailure: d.ExitOnFailure,
+	}
+	if len(d.SensorThresholds) > 0 {
+		c.SensorThresholds = make(map[string]string, len(d.SensorThresholds))
+		for name, t := range d.SensorThresholds {
+			c.SensorThresholds[name] = t.String()
+		}
+	}
+	if d.Mode == modePID {
+		c.PID = &pidConfig{
+			Target:           d.PIDTarget,
+			Kp:               d.PIDKp,
+			Ki:               d.PIDKi,
+			Kd:               d.PIDKd,
+			MinSpin:          d.PIDMinSpin,
+			DerivativeFilter: d.PIDDerivativeFilter.String(),
+		}
+	}
+	if d.Adaptive {
+		c.MinInterval, c.MaxInterval = d.MinInterval.String(), d.MaxInterval.String()
+	}
+	switch d.Filter {
+	case filterAverage, filterMedian:
+		c.FilterWindow = d.FilterWindow
+	case filterEMA:
+		c.FilterAlpha = d.FilterAlpha
+	}
+	return c
+}
@@ -20,6 +20,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/hashicorp/go-hclog"
@@ -30,27 +31,27 @@ type temperatureCmd struct {
 	Imperial bool `short:"i" long:"imperial" help:"Display temperature in imperial system" default:"false" env:"-"`
 }
 
-func (tc *temperatureCmd) Run(logger hclog.Logger, thermalReaderOptions []argononefan.ThermalReaderOption) error {
+func (tc *temperatureCmd) Run(logger hclog.Logger, newReader readerFunc) error {
 
 	ml := logger.Named("temperature")
 	ml.Debug("Creating thermal reader")
 
-	tr, err := argononefan.NewThermalReader(thermalReaderOptions...)
+	tr, err := newReader()
 	if err != nil {
 		return fmt.Errorf("creating thermal reader: %w", err)
 	}
 
-	t, readErr := tr.Celsius()
-	frmt := "Temperature: %2.1f°C\n"
-	if tc.Imperial {
-		t, readErr = tr.Fahrenheit()
-		frmt = "Temperature: %3.1f°F\n"
+	r, err := tr.Read(context.Background())
+	if err != nil {
+		return fmt.Errorf("reading temperature: %w", err)
 	}
+	ml.Debug("Read temperature", "source", r.Source, "time", r.Time)
 
-	if readErr != nil {
-		return fmt.Errorf("reading temperature: %w", readErr)
+	scale := argononefan.ScaleCelsius
+	if tc.Imperial {
+		scale = argononefan.ScaleFahrenheit
 	}
-	_, werr := fmt.Printf(frmt, t)
+	_, werr := fmt.Printf("Temperature: %s\n", r.Temperature.StringIn(scale))
 
 	return werr
 }
@@ -0,0 +1,194 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  control.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+// Package control provides the policies deriving the fan speed
+// from the temperature.
+package control
+
+import "time"
+
+// Sample is a temperature reading.
+type Sample struct {
+	// Temperature in °C.
+	Temperature float32
+	// Time the temperature was read at.
+	Time time.Time
+	// Sensors holds the readings in °C of the individual sensors by name,
+	// if Temperature was combined from several sensors.
+	Sensors map[string]float32
+}
+
+// Controller computes the fan speed in percent for a reading.
+//
+// current is the speed the fan is currently running at,
+// or -1 if it is unknown.
+type Controller interface {
+	Speed(s Sample, current int) int
+}
+
+// State describes how a Controller arrived at the last speed it returned.
+type State struct {
+	// Threshold is the temperature in °C of the highest threshold
+	// the temperature is at or above. It is only valid if Active is set.
+	Threshold float32
+	// Active is false if the temperature is below all thresholds.
+	Active bool
+	// Holding is set if the fan is kept from slowing down by the hysteresis.
+	Holding bool
+}
+
+// StateReporter is implemented by Controllers which can report
+// the State of their last decision, like ThresholdController.
+type StateReporter interface {
+	State() State
+}
+
+// ControllerFunc is an adapter to use an ordinary function as Controller.
+type ControllerFunc func(s Sample, current int) int
+
+// Speed calls f(s, current).
+func (f ControllerFunc) Speed(s Sample, current int) int {
+	return f(s, current)
+}
+
+// ThresholdController is a Controller deriving the fan speed from Thresholds.
+//
+// The fan is never slowed down before the temperature has dropped
+// Hysteresis °C below the point the current speed was reached at.
+type ThresholdController struct {
+	Thresholds *Thresholds
+	Hysteresis float32
+	Curve      Curve
+
+	state State
+}
+
+// Speed implements Controller.
+func (c *ThresholdController) Speed(s Sample, current int) int {
+	target := c.Thresholds.SpeedAt(s.Temperature, c.Curve)
+	holding := false
+	if target < current {
+		// Never speed up while the temperature is falling.
+		held := min(current, c.Thresholds.SpeedAtWithHysteresis(s.Temperature, c.Hysteresis, c.Curve))
+		holding = held > target
+		target = held
+	}
+
+	c.state = State{Holding: holding}
+	for _, th := range c.Thresholds.Temperatures() {
+		if s.Temperature >= th {
+			c.state.Threshold, c.state.Active = th, true
+			break
+		}
+	}
+	return target
+}
+
+// State implements StateReporter.
+func (c *ThresholdController) State() State {
+	return c.state
+}
+
+// PerSensorController is a Controller applying a Controller to the reading
+// of each sensor individually. The highest speed demanded wins.
+//
+// If no Controller applies to a Sample, the fan runs at full speed.
+type PerSensorController struct {
+	// NewDefault creates the Controller of each sensor without one of its own,
+	// and the Controller for samples without readings of individual sensors.
+	// Every sensor gets an instance of its own, so that stateful Controllers
+	// do not mix up the readings of different sensors.
+	// If NewDefault is nil, such sensors are skipped.
+	NewDefault func() Controller
+	// Sensors maps names of sensors to their Controller.
+	Sensors map[string]Controller
+
+	// combined is the default Controller for samples without individual readings.
+	combined Controller
+	// defaults holds the default Controllers created for sensors by name.
+	defaults map[string]Controller
+	state    State
+}
+
+// Speed implements Controller.
+func (c *PerSensorController) Speed(s Sample, current int) int {
+	if len(s.Sensors) == 0 {
+		if c.combined == nil && c.NewDefault != nil {
+			c.combined = c.NewDefault()
+		}
+		if c.combined == nil {
+			c.state = State{}
+			return 100
+		}
+		speed := c.combined.Speed(s, current)
+		c.state = stateOf(c.combined)
+		return speed
+	}
+
+	speed := -1
+	for name, t := range s.Sensors {
+		ctrl := c.controllerOf(name)
+		if ctrl == nil {
+			continue
+		}
+		if sensorSpeed := ctrl.Speed(Sample{Temperature: t, Time: s.Time}, current); sensorSpeed > speed {
+			speed = sensorSpeed
+			c.state = stateOf(ctrl)
+		}
+	}
+	if speed < 0 {
+		c.state = State{}
+		return 100
+	}
+	return speed
+}
+
+// controllerOf returns the Controller of the sensor name,
+// or nil if it has none and there is no NewDefault.
+func (c *PerSensorController) controllerOf(name string) Controller {
+	if ctrl, ok := c.Sensors[name]; ok {
+		return ctrl
+	}
+	if ctrl, ok := c.defaults[name]; ok {
+		return ctrl
+	}
+	if c.NewDefault == nil {
+		return nil
+	}
+	if c.defaults == nil {
+		c.defaults = make(map[string]Controller)
+	}
+	ctrl := c.NewDefault()
+	c.defaults[name] = ctrl
+	return ctrl
+}
+
+// State implements StateReporter. It is the State of the Controller
+// of the sensor which demanded the highest speed.
+func (c *PerSensorController) State() State {
+	return c.state
+}
+
+// stateOf returns the State of ctrl, if it is a StateReporter.
+func stateOf(ctrl Controller) State {
+	if sr, ok := ctrl.(StateReporter); ok {
+		return sr.State()
+	}
+	return State{}
+}
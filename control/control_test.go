@@ -0,0 +1,70 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdController(t *testing.T) {
+	c := &ThresholdController{
+		Thresholds: NewThresholds(map[float32]int{70: 100, 60: 50, 55: 10}),
+		Hysteresis: 1,
+		Curve:      CurveStep,
+	}
+	now := time.Now()
+
+	assert.Equal(t, 0, c.Speed(Sample{Temperature: 50, Time: now}, -1))
+	assert.Equal(t, 50, c.Speed(Sample{Temperature: 61, Time: now}, 0), "rising temperatures speed the fan up")
+	assert.Equal(t, State{Threshold: 60, Active: true}, c.State())
+	assert.Equal(t, 50, c.Speed(Sample{Temperature: 59.5, Time: now}, 50), "within the hysteresis, the speed is kept")
+	assert.Equal(t, State{Threshold: 55, Active: true, Holding: true}, c.State())
+	assert.Equal(t, 10, c.Speed(Sample{Temperature: 58.5, Time: now}, 50), "below the hysteresis, the fan slows down")
+	assert.Equal(t, State{Threshold: 55, Active: true}, c.State())
+	c.Speed(Sample{Temperature: 50, Time: now}, 0)
+	assert.False(t, c.State().Active)
+}
+
+func TestPerSensorController(t *testing.T) {
+	c := &PerSensorController{
+		NewDefault: func() Controller {
+			return &ThresholdController{Thresholds: NewThresholds(map[float32]int{70: 100, 60: 50}), Curve: CurveStep}
+		},
+		Sensors: map[string]Controller{
+			"nvme": &ThresholdController{Thresholds: NewThresholds(map[float32]int{55: 100, 45: 30}), Curve: CurveStep},
+		},
+	}
+	now := time.Now()
+
+	assert.Equal(t, 50, c.Speed(Sample{Temperature: 62, Time: now}, -1), "without individual readings, the default applies")
+	assert.Equal(t, 30, c.Speed(Sample{Temperature: 50, Time: now, Sensors: map[string]float32{"cpu": 50, "nvme": 50}}, -1))
+	assert.Equal(t, 50, c.Speed(Sample{Temperature: 62, Time: now, Sensors: map[string]float32{"cpu": 62, "nvme": 50}}, -1))
+	assert.Equal(t, 100, c.Speed(Sample{Temperature: 62, Time: now, Sensors: map[string]float32{"cpu": 62, "nvme": 56}}, -1))
+	assert.Equal(t, State{Threshold: 55, Active: true}, c.State(), "the state is the one of the sensor demanding the highest speed")
+}
+
+func TestPerSensorControllerDefaults(t *testing.T) {
+	c := &PerSensorController{
+		NewDefault: func() Controller {
+			// Counts the samples it has seen.
+			samples := 0
+			return ControllerFunc(func(Sample, int) int {
+				samples++
+				return samples
+			})
+		},
+	}
+	now := time.Now()
+
+	assert.Equal(t, 1, c.Speed(Sample{Temperature: 50, Time: now, Sensors: map[string]float32{"cpu": 50, "gpu": 40}}, 0))
+	assert.Equal(t, 2, c.Speed(Sample{Temperature: 50, Time: now, Sensors: map[string]float32{"cpu": 50, "gpu": 40}}, 0), "every sensor must get a default controller of its own")
+	assert.Equal(t, 1, c.Speed(Sample{Temperature: 50, Time: now}, 0), "samples without individual readings must get a default controller of their own")
+
+	c = &PerSensorController{}
+	assert.Equal(t, 100, c.Speed(Sample{Temperature: 40, Time: now}, 0), "without a controller, the fan must run at full speed")
+	assert.Equal(t, 100, c.Speed(Sample{Temperature: 40, Time: now, Sensors: map[string]float32{"cpu": 40}}, 0))
+
+	c.Sensors = map[string]Controller{"nvme": &ThresholdController{Thresholds: NewThresholds(map[float32]int{55: 100, 45: 30}), Curve: CurveStep}}
+	assert.Equal(t, 30, c.Speed(Sample{Temperature: 80, Time: now, Sensors: map[string]float32{"cpu": 80, "nvme": 50}}, 0), "sensors without a controller must be skipped")
+}
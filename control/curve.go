@@ -0,0 +1,136 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  curve.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package control
+
+import (
+	"math"
+
+	"golang.org/x/exp/slices"
+)
+
+// Curve determines how the fan speed is derived from the thresholds.
+type Curve string
+
+const (
+	// CurveStep uses the speed of the highest threshold at or below the temperature.
+	CurveStep Curve = "step"
+	// CurveLinear interpolates linearly between the thresholds.
+	CurveLinear Curve = "linear"
+	// CurveSpline interpolates between the thresholds using a monotone cubic spline,
+	// which never overshoots the speeds of the neighbouring thresholds.
+	CurveSpline Curve = "spline"
+)
+
+// SpeedAt returns the fan speed for the given temperature.
+//
+// For all modes, the fan is off below the lowest threshold
+// and runs at the speed of the highest threshold above it.
+func (t *Thresholds) SpeedAt(temperature float32, mode Curve) int {
+	if mode == CurveStep || mode == "" {
+		return t.GetSpeed(temperature)
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	xs := slices.Clone(t.idx)
+	slices.Reverse(xs)
+	if len(xs) == 0 || temperature < xs[0] {
+		return 0
+	}
+	if temperature >= xs[len(xs)-1] {
+		return t.thresholds[xs[len(xs)-1]]
+	}
+
+	ys := make([]float64, len(xs))
+	for i, x := range xs {
+		ys[i] = float64(t.thresholds[x])
+	}
+
+	// The segment containing the temperature.
+	k := 0
+	for temperature >= xs[k+1] {
+		k++
+	}
+
+	var speed float64
+	switch mode {
+	case CurveSpline:
+		speed = hermite(xs, ys, monotoneTangents(xs, ys), k, float64(temperature))
+	default:
+		s := float64(temperature-xs[k]) / float64(xs[k+1]-xs[k])
+		speed = ys[k] + s*(ys[k+1]-ys[k])
+	}
+
+	return int(math.Max(0, math.Min(100, math.Round(speed))))
+}
+
+// SpeedAtWithHysteresis returns the fan speed for the given temperature
+// when the fan is slowing down.
+//
+// For the step mode, this is the same as GetSpeedWithHysteresis. For the
+// interpolating modes, the curve is shifted by the hysteresis towards lower
+// temperatures, so the speed lags behind a falling temperature by hysteresis °C.
+func (t *Thresholds) SpeedAtWithHysteresis(temperature float32, hysteresis float32, mode Curve) int {
+	if mode == CurveStep || mode == "" {
+		return t.GetSpeedWithHysteresis(temperature, hysteresis)
+	}
+	return t.SpeedAt(temperature+hysteresis, mode)
+}
+
+// monotoneTangents computes the tangents of a monotone cubic
+// Hermite spline through the given points using the
+// Fritsch-Carlson method.
+func monotoneTangents(xs []float32, ys []float64) []float64 {
+	n := len(xs)
+	secants := make([]float64, n-1)
+	for k := 0; k < n-1; k++ {
+		secants[k] = (ys[k+1] - ys[k]) / float64(xs[k+1]-xs[k])
+	}
+
+	m := make([]float64, n)
+	m[0], m[n-1] = secants[0], secants[n-2]
+	for k := 1; k < n-1; k++ {
+		if secants[k-1]*secants[k] > 0 {
+			m[k] = (secants[k-1] + secants[k]) / 2
+		}
+	}
+
+	for k := 0; k < n-1; k++ {
+		if secants[k] == 0 {
+			m[k], m[k+1] = 0, 0
+			continue
+		}
+		a, b := m[k]/secants[k], m[k+1]/secants[k]
+		if h := a*a + b*b; h > 9 {
+			tau := 3 / math.Sqrt(h)
+			m[k], m[k+1] = tau*a*secants[k], tau*b*secants[k]
+		}
+	}
+	return m
+}
+
+// hermite evaluates the cubic Hermite spline segment k at x.
+func hermite(xs []float32, ys, m []float64, k int, x float64) float64 {
+	h := float64(xs[k+1] - xs[k])
+	s := (x - float64(xs[k])) / h
+	s2, s3 := s*s, s*s*s
+	return (2*s3-3*s2+1)*ys[k] + (s3-2*s2+s)*h*m[k] + (-2*s3+3*s2)*ys[k+1] + (s3-s2)*h*m[k+1]
+}
@@ -0,0 +1,92 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  pid.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package control
+
+import (
+	"math"
+	"time"
+)
+
+// PID is a Controller computing the fan speed required to hold
+// the temperature at a setpoint.
+//
+// The error is the amount the temperature is above the setpoint,
+// so the gains are in percent fan speed per °C (Kp), per °C and second (Ki)
+// and per °C per second (Kd).
+//
+// The zero state is ready to use once configured. A PID keeps state
+// between readings and must not be shared between control loops.
+type PID struct {
+	Setpoint   float64
+	Kp, Ki, Kd float64
+	// MinSpin is the lowest speed the fan is run at, if it runs at all.
+	MinSpin float64
+	// Filter is the time constant of the low-pass filter
+	// applied to the derivative.
+	Filter time.Duration
+
+	integral   float64
+	derivative float64
+	lastTemp   float64
+	lastTime   time.Time
+}
+
+// Speed implements Controller. The current speed of the fan is ignored.
+func (p *PID) Speed(s Sample, current int) int {
+	return p.update(s.Temperature, s.Time)
+}
+
+func (p *PID) update(temperature float32, now time.Time) int {
+	t := float64(temperature)
+	e := t - p.Setpoint
+
+	if !p.lastTime.IsZero() {
+		if dt := now.Sub(p.lastTime).Seconds(); dt > 0 {
+			// The derivative is taken on the measurement instead of the error,
+			// which is the same as long as the setpoint does not change.
+			raw := (t - p.lastTemp) / dt
+			alpha := 1.0
+			if tau := p.Filter.Seconds(); tau > 0 {
+				alpha = dt / (tau + dt)
+			}
+			p.derivative += alpha * (raw - p.derivative)
+
+			// Anti-windup by conditional integration: the integral only
+			// grows if that does not drive the output further into saturation.
+			integral := p.integral + e*dt
+			u := p.Kp*e + p.Ki*integral + p.Kd*p.derivative
+			if !(u > 100 && e > 0) && !(u < 0 && e < 0) {
+				p.integral = integral
+			}
+		}
+	}
+	p.lastTemp, p.lastTime = t, now
+
+	u := math.Round(p.Kp*e + p.Ki*p.integral + p.Kd*p.derivative)
+	switch {
+	case u <= 0:
+		return 0
+	case u >= 100:
+		return 100
+	case u < p.MinSpin:
+		return int(p.MinSpin)
+	}
+	return int(u)
+}
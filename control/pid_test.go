@@ -0,0 +1,23 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPIDController(t *testing.T) {
+	pid := &PID{Setpoint: 55, Kp: 5, Ki: 0.1, MinSpin: 20}
+	now := time.Now()
+
+	assert.Equal(t, 0, pid.update(50, now), "below the setpoint, the fan is off")
+	assert.Equal(t, 20, pid.update(55.4, now.Add(5*time.Second)), "minimum spin applies")
+	assert.Equal(t, 100, pid.update(80, now.Add(10*time.Second)), "output is clamped")
+
+	// Stay saturated for a long time: the integral must not wind up.
+	for i := 1; i <= 100; i++ {
+		pid.update(80, now.Add(time.Duration(10+5*i)*time.Second))
+	}
+	assert.Less(t, pid.update(56, now.Add(520*time.Second)), 100, "output must recover quickly after saturation")
+}
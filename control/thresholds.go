@@ -0,0 +1,155 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  thresholds.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package control
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Thresholds maps temperatures in °C to fan speeds in percent.
+//
+// Thresholds implements encoding.TextUnmarshaler for the format
+// "70=100;60=50;55=10". It is safe for concurrent use.
+type Thresholds struct {
+	mu         sync.RWMutex
+	thresholds map[float32]int
+	idx        []float32
+}
+
+// NewThresholds creates Thresholds from a map of temperatures in °C to fan speeds in percent.
+func NewThresholds(thresholds map[float32]int) *Thresholds {
+	t := &Thresholds{thresholds: maps.Clone(thresholds)}
+	t.GenerateIndex()
+	return t
+}
+
+// UnmarshalText parses thresholds in the format "70=100;60=50;55=10".
+// Speeds must be in [0, 100].
+func (t *Thresholds) UnmarshalText(text []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.unmarshalText(text); err != nil {
+		return err
+	}
+	t.generateIndex()
+	return nil
+}
+
+// unmarshalText parses text into t. t.mu must be held.
+func (t *Thresholds) unmarshalText(text []byte) error {
+	t.thresholds = make(map[float32]int)
+	for _, val := range strings.Split(string(text), ";") {
+		kv := strings.Split(val, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("not a key/value pair: %s", val)
+		}
+		f, err := strconv.ParseFloat(kv[0], 32)
+		if err != nil {
+			return fmt.Errorf("parsing key %s: %s", kv[0], err)
+		}
+		i, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return fmt.Errorf("parsing value %s: %s", kv[1], err)
+		}
+		if i < 0 || i > 100 {
+			return fmt.Errorf("fan speed for %2.1f°C is out of range: %d", f, i)
+		}
+		t.thresholds[float32(f)] = i
+	}
+	return nil
+}
+
+// GetSpeed returns the speed of the highest threshold at or below temperature.
+func (t *Thresholds) GetSpeed(temperature float32) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, th := range t.idx {
+		if temperature >= th {
+			return t.thresholds[th]
+		}
+	}
+	return 0
+}
+
+// GetSpeedWithHysteresis returns the speed of the highest threshold
+// temperature has not dropped hysteresis °C below.
+func (t *Thresholds) GetSpeedWithHysteresis(temperature float32, hysteresis float32) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, th := range t.idx {
+		if temperature > th-hysteresis {
+			return t.thresholds[th]
+		}
+	}
+	return 0
+}
+
+// GetThreshold returns the highest threshold at or below temperature.
+func (t *Thresholds) GetThreshold(temperature float32) float32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, th := range t.idx {
+		if temperature >= th {
+			return th
+		}
+	}
+	return 0
+}
+
+// Temperatures returns the temperatures of the thresholds,
+// ordered from the highest to the lowest.
+func (t *Thresholds) Temperatures() []float32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return slices.Clone(t.idx)
+}
+
+// String returns the thresholds in the format accepted by UnmarshalText,
+// ordered from the highest to the lowest temperature.
+func (t *Thresholds) String() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	pairs := make([]string, len(t.idx))
+	for i, th := range t.idx {
+		pairs[i] = strconv.FormatFloat(float64(th), 'f', -1, 32) + "=" + strconv.Itoa(t.thresholds[th])
+	}
+	return strings.Join(pairs, ";")
+}
+
+// GenerateIndex orders the thresholds. It must be called after
+// the thresholds have been modified.
+func (t *Thresholds) GenerateIndex() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.generateIndex()
+}
+
+// generateIndex orders the thresholds. t.mu must be held.
+func (t *Thresholds) generateIndex() {
+	t.idx = maps.Keys(t.thresholds)
+	slices.Sort(t.idx)
+	slices.Reverse(t.idx)
+}
@@ -0,0 +1,82 @@
+package control
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHysteresis(t *testing.T) {
+	thresholds := &Thresholds{
+		thresholds: map[float32]int{
+			60: 100,
+			55: 50,
+			50: 10,
+		},
+	}
+	thresholds.GenerateIndex()
+	assert.Equal(t, 100, thresholds.GetSpeed(60))
+	assert.Equal(t, 50, thresholds.GetSpeed(55))
+	assert.Equal(t, 50, thresholds.GetSpeedWithHysteresis(54.5, 1))
+	assert.Equal(t, 10, thresholds.GetSpeedWithHysteresis(54, 1))
+	assert.Equal(t, 0, thresholds.GetSpeedWithHysteresis(49, 1))
+}
+
+func TestCurves(t *testing.T) {
+	thresholds := &Thresholds{
+		thresholds: map[float32]int{
+			70: 100,
+			60: 50,
+			50: 10,
+		},
+	}
+	thresholds.GenerateIndex()
+
+	for _, mode := range []Curve{CurveLinear, CurveSpline} {
+		assert.Equal(t, 0, thresholds.SpeedAt(49.9, mode), mode)
+		assert.Equal(t, 10, thresholds.SpeedAt(50, mode), mode)
+		assert.Equal(t, 50, thresholds.SpeedAt(60, mode), mode)
+		assert.Equal(t, 100, thresholds.SpeedAt(75, mode), mode)
+
+		last := 0
+		for temp := float32(50); temp <= 70; temp += 0.5 {
+			speed := thresholds.SpeedAt(temp, mode)
+			assert.GreaterOrEqual(t, speed, last, "%s must be monotone at %2.1f°C", mode, temp)
+			last = speed
+		}
+	}
+
+	assert.Equal(t, 30, thresholds.SpeedAt(55, CurveLinear))
+	assert.Equal(t, 75, thresholds.SpeedAt(65, CurveLinear))
+	assert.Equal(t, 30, thresholds.SpeedAtWithHysteresis(54, 1, CurveLinear))
+	assert.Equal(t, 50, thresholds.SpeedAtWithHysteresis(59.5, 1, CurveStep))
+}
+
+func TestThresholdsConcurrentUse(t *testing.T) {
+	thresholds := NewThresholds(map[float32]int{70: 100, 60: 50})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			thresholds.GenerateIndex()
+			assert.NoError(t, thresholds.UnmarshalText([]byte("70=100;60=50")))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, 50, thresholds.SpeedAt(65, CurveStep))
+			assert.Equal(t, 75, thresholds.SpeedAt(65, CurveLinear))
+		}
+	}()
+	wg.Wait()
+}
+
+func TestThresholdsOutOfRange(t *testing.T) {
+	thresholds := &Thresholds{}
+	assert.EqualError(t, thresholds.UnmarshalText([]byte("70=150;60=50")), "fan speed for 70.0°C is out of range: 150")
+	assert.Error(t, thresholds.UnmarshalText([]byte("70=-1")))
+	assert.NoError(t, thresholds.UnmarshalText([]byte("70=100;60=0")))
+}
@@ -0,0 +1,103 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  adaptive.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package daemon
+
+import (
+	"math"
+	"time"
+
+	"github.com/mwmahlberg/argononefan/control"
+)
+
+const (
+	// Temperature changes faster than this (in °C per second) are
+	// considered a sudden load change, which is polled at the minimum interval.
+	adaptiveFastRate = 0.2
+	// Temperature changes slower than this (in °C per second) are
+	// considered stable, which allows to back off towards the maximum interval.
+	adaptiveStableRate = 0.02
+	// Within this distance (in °C) to a threshold, the minimum interval is used.
+	adaptiveNearThreshold = 2.0
+	// Beyond this distance (in °C) to any threshold, the interval may back off.
+	adaptiveFarFromThreshold = 5.0
+)
+
+// Poller computes the interval until the next reading.
+type Poller interface {
+	Next(s control.Sample) time.Duration
+}
+
+// AdaptivePoller is a Poller basing the interval until the next reading
+// on how fast the temperature changes and how close it is to a threshold.
+type AdaptivePoller struct {
+	min, base, max time.Duration
+	thresholds     *control.Thresholds
+	hysteresis     float32
+
+	current  time.Duration
+	lastTemp float32
+	lastTime time.Time
+}
+
+// NewAdaptivePoller creates an AdaptivePoller polling at intervals between min and max,
+// starting at base. The thresholds and hysteresis are those the fan is controlled by.
+func NewAdaptivePoller(min, base, max time.Duration, thresholds *control.Thresholds, hysteresis float32) *AdaptivePoller {
+	return &AdaptivePoller{min: min, base: base, max: max, current: base, thresholds: thresholds, hysteresis: hysteresis}
+}
+
+// Next returns the interval until the next reading, given the current reading.
+func (p *AdaptivePoller) Next(s control.Sample) time.Duration {
+	temperature, now := s.Temperature, s.Time
+	var rate float64
+	if !p.lastTime.IsZero() {
+		if dt := now.Sub(p.lastTime).Seconds(); dt > 0 {
+			rate = math.Abs(float64(temperature-p.lastTemp)) / dt
+		}
+	}
+	p.lastTemp, p.lastTime = temperature, now
+
+	distance := math.Inf(1)
+	for _, th := range p.thresholds.Temperatures() {
+		// Both the threshold itself and the point the fan slows down
+		// again are of interest.
+		for _, edge := range []float32{th, th - p.hysteresis} {
+			distance = math.Min(distance, math.Abs(float64(temperature-edge)))
+		}
+	}
+
+	switch {
+	case rate >= adaptiveFastRate || distance <= adaptiveNearThreshold:
+		p.current = p.min
+	case rate < adaptiveStableRate && distance > adaptiveFarFromThreshold:
+		p.current = p.current * 2
+		if p.current < p.base {
+			p.current = p.base
+		}
+	default:
+		p.current = p.base
+	}
+
+	if p.current < p.min {
+		p.current = p.min
+	} else if p.current > p.max {
+		p.current = p.max
+	}
+	return p.current
+}
@@ -0,0 +1,31 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptivePoller(t *testing.T) {
+	config := control.NewThresholds(map[float32]int{70: 100, 60: 50})
+
+	p := NewAdaptivePoller(time.Second, 5*time.Second, 30*time.Second, config, 1)
+	now := time.Now()
+
+	// Idle and stable: back off until the maximum is reached.
+	assert.Equal(t, 10*time.Second, p.Next(control.Sample{Temperature: 45, Time: now}))
+	assert.Equal(t, 20*time.Second, p.Next(control.Sample{Temperature: 45, Time: now.Add(10 * time.Second)}))
+	assert.Equal(t, 30*time.Second, p.Next(control.Sample{Temperature: 45, Time: now.Add(30 * time.Second)}))
+	assert.Equal(t, 30*time.Second, p.Next(control.Sample{Temperature: 45, Time: now.Add(60 * time.Second)}))
+
+	// Sudden load: poll as fast as allowed.
+	assert.Equal(t, time.Second, p.Next(control.Sample{Temperature: 52, Time: now.Add(90 * time.Second)}))
+
+	// Neither stable nor close to a threshold: the base interval.
+	assert.Equal(t, 5*time.Second, p.Next(control.Sample{Temperature: 52.5, Time: now.Add(110 * time.Second)}))
+
+	// Close to a threshold.
+	assert.Equal(t, time.Second, p.Next(control.Sample{Temperature: 58.5, Time: now.Add(200 * time.Second)}))
+}
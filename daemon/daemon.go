@@ -0,0 +1,575 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  daemon.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+// Package daemon provides the control loop driving the fan
+// of an ArgonOne case according to the CPU temperature.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/exp/maps"
+)
+
+// DefaultInterval is the default interval between two temperature readings.
+const DefaultInterval = 5 * time.Second
+
+// shutdownReadTimeout limits reading the temperature for the log on exit.
+const shutdownReadTimeout = time.Second
+
+// SafetySpeed is the fan speed in percent set on start and on exit,
+// when the temperature is unknown.
+const SafetySpeed = 100
+
+// DefaultMaxFailures is the default number of consecutive failed readings
+// after which the fan is set to the fail-safe speed.
+const DefaultMaxFailures = 3
+
+var (
+	// ErrNoReader is returned by New if no TemperatureReader was set.
+	ErrNoReader = errors.New("no temperature reader set")
+	// ErrNoFan is returned by New if no Fan was set.
+	ErrNoFan = errors.New("no fan set")
+	// ErrNoController is returned by New if no control.Controller was set.
+	ErrNoController = errors.New("no controller set")
+	// ErrSensorFailure is returned by Run if the temperature could not be read
+	// repeatedly and the Daemon was configured to exit with WithExitOnFailure.
+	ErrSensorFailure = errors.New("sensor failure")
+)
+
+// TemperatureReader reads the temperature.
+// *argononefan.ThermalReader and *argononefan.MultiReader implement TemperatureReader.
+type TemperatureReader interface {
+	Read(ctx context.Context) (argononefan.Reading, error)
+}
+
+// SensorReader is implemented by TemperatureReaders combining several sensors,
+// like *argononefan.MultiReader. The readings of the individual sensors
+// are passed to the control.Controller with each sample.
+type SensorReader interface {
+	Readings() map[string]float32
+}
+
+// Notifier is told about every iteration of the control loop,
+// for example to report to a service manager like systemd.
+type Notifier interface {
+	// Notify is called with the current Status after every iteration.
+	// healthy is set if the temperature was read and the fan runs
+	// at the speed demanded.
+	Notify(st Status, healthy bool)
+}
+
+// Fan is the fan controlled by a Daemon.
+// *argononefan.Fan implements Fan. SetSpeed must return an error
+// unless the speed was set, so that the Daemon retries it.
+type Fan interface {
+	SetSpeed(speed int) error
+}
+
+// modeler is implemented by Fans which know the model of the case,
+// like *argononefan.Fan.
+type modeler interface {
+	Model() argononefan.Model
+}
+
+// Option is a function that configures a Daemon.
+type Option func(*Daemon) error
+
+// WithReader sets the TemperatureReader the temperature is read from.
+func WithReader(r TemperatureReader) Option {
+	return func(d *Daemon) error {
+		d.reader = r
+		return nil
+	}
+}
+
+// WithFan sets the Fan to control.
+func WithFan(f Fan) Option {
+	return func(d *Daemon) error {
+		d.fan = f
+		return nil
+	}
+}
+
+// WithController sets the control.Controller deriving the fan speed from the temperature.
+func WithController(c control.Controller) Option {
+	return func(d *Daemon) error {
+		d.settings.controller = c
+		return nil
+	}
+}
+
+// WithInterval sets the interval between two temperature readings.
+// The default is DefaultInterval.
+func WithInterval(interval time.Duration) Option {
+	return func(d *Daemon) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive: %s", interval)
+		}
+		d.settings.interval = interval
+		return nil
+	}
+}
+
+// WithPoller sets a Poller adjusting the interval after every reading.
+// If p is nil, which is the default, the interval set with WithInterval is used.
+func WithPoller(p Poller) Option {
+	return func(d *Daemon) error {
+		d.settings.poller = p
+		return nil
+	}
+}
+
+// WithMaxFailures sets the number of consecutive failed readings after which
+// the fan is set to the fail-safe speed. Until then, the fan speed is kept.
+// The default is DefaultMaxFailures.
+func WithMaxFailures(n int) Option {
+	return func(d *Daemon) error {
+		if n < 1 {
+			return fmt.Errorf("maximum number of failures must be at least 1: %d", n)
+		}
+		d.settings.maxFailures = n
+		return nil
+	}
+}
+
+// WithFailSafeSpeed sets the fan speed in percent used while the temperature
+// can not be read. The default is SafetySpeed. As a broken sensor must never
+// stop the fan, speed must be in [1, 100].
+func WithFailSafeSpeed(speed int) Option {
+	return func(d *Daemon) error {
+		if speed < 1 || speed > 100 {
+			return fmt.Errorf("fail-safe speed is out of range: %d", speed)
+		}
+		d.settings.failSafeSpeed = speed
+		return nil
+	}
+}
+
+// WithExitOnFailure makes Run return ErrSensorFailure instead of
+// setting the fail-safe speed, so that a supervisor can restart the Daemon.
+// The fan is set to SafetySpeed on exit nonetheless.
+func WithExitOnFailure(exit bool) Option {
+	return func(d *Daemon) error {
+		d.settings.exitOnFailure = exit
+		return nil
+	}
+}
+
+// WithLogger sets the logger. By default, nothing is logged.
+func WithLogger(logger hclog.Logger) Option {
+	return func(d *Daemon) error {
+		d.logger = logger
+		return nil
+	}
+}
+
+// WithNotifier sets a Notifier called after every iteration of the control loop.
+// Notify must not block, as it delays the control loop.
+func WithNotifier(n Notifier) Option {
+	return func(d *Daemon) error {
+		d.notifier = n
+		return nil
+	}
+}
+
+// WithVersion sets the version exposed with the build info metric.
+func WithVersion(version string) Option {
+	return func(d *Daemon) error {
+		d.version = version
+		return nil
+	}
+}
+
+// WithRegistry sets the registry the metrics of the Daemon are registered with.
+// By default, the metrics are not registered, so that several Daemons
+// can be created in the same process.
+func WithRegistry(reg prometheus.Registerer) Option {
+	return func(d *Daemon) error {
+		d.registry = reg
+		return nil
+	}
+}
+
+// settings are the settings of the control loop
+// which can be changed while it is running.
+type settings struct {
+	controller    control.Controller
+	interval      time.Duration
+	poller        Poller
+	maxFailures   int
+	failSafeSpeed int
+	exitOnFailure bool
+}
+
+// Daemon reads the temperature in regular intervals
+// and sets the fan speed as determined by its control.Controller.
+type Daemon struct {
+	reader   TemperatureReader
+	fan      Fan
+	logger   hclog.Logger
+	registry prometheus.Registerer
+	metrics  *metrics
+	notifier Notifier
+	version  string
+	status   status
+
+	mu       sync.Mutex
+	settings settings
+	reloadC  chan settings
+	override *Override
+	paused   bool
+	// wakeC makes the control loop run before the next tick.
+	wakeC chan struct{}
+}
+
+// New creates a new Daemon. WithReader, WithFan and WithController are required.
+func New(opts ...Option) (*Daemon, error) {
+	d := &Daemon{
+		logger:   hclog.NewNullLogger(),
+		version:  "unknown",
+		settings: settings{interval: DefaultInterval, maxFailures: DefaultMaxFailures, failSafeSpeed: SafetySpeed},
+		// Buffered, so that Reconfigure never blocks.
+		reloadC: make(chan settings, 1),
+		wakeC:   make(chan struct{}, 1),
+		status:  status{speed: -1, targetSpeed: -1},
+	}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, fmt.Errorf("creating daemon: %w", err)
+		}
+	}
+
+	switch {
+	case d.reader == nil:
+		return nil, ErrNoReader
+	case d.fan == nil:
+		return nil, ErrNoFan
+	case d.settings.controller == nil:
+		return nil, ErrNoController
+	}
+
+	model := "unknown"
+	if m, ok := d.fan.(modeler); ok {
+		model = m.Model().String()
+	}
+	m, err := newMetrics(d.registry, d.version, model)
+	if err != nil {
+		return nil, fmt.Errorf("creating daemon: %w", err)
+	}
+	d.metrics = m
+	return d, nil
+}
+
+// Reconfigure changes the controller, interval, poller and failure policy of a running Daemon.
+// The control loop picks up the changes before the next reading.
+// Other options are ignored.
+func (d *Daemon) Reconfigure(opts ...Option) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	next := &Daemon{settings: d.settings}
+	for _, opt := range opts {
+		if err := opt(next); err != nil {
+			return fmt.Errorf("reconfiguring daemon: %w", err)
+		}
+	}
+	if next.settings.controller == nil {
+		return ErrNoController
+	}
+	d.settings = next.settings
+
+	// Replace pending settings the control loop has not picked up yet.
+	// Sending is serialized by the mutex, so there is room afterwards.
+	select {
+	case <-d.reloadC:
+	default:
+	}
+	d.reloadC <- next.settings
+	return nil
+}
+
+// Run sets the fan to SafetySpeed and then controls it until ctx is done.
+// On exit, the fan is set to SafetySpeed again.
+//
+// Run returns an error if the fan could not be set initially or, with
+// WithExitOnFailure, an error wrapping ErrSensorFailure if the temperature
+// could not be read repeatedly. Other errors while running are logged
+// and do not stop the Daemon.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.logger.Info("Setting initial fan speed as a safety measure", "speed", SafetySpeed, "reason", "we don't know the current CPU temperature yet")
+	d.status.update(func(s *status) { s.started = time.Now() })
+	if err := d.fan.SetSpeed(SafetySpeed); err != nil {
+		d.metrics.fanSpeedSetFailed.Inc()
+		d.status.update(func(s *status) {
+			s.fanErr = err
+			s.failed(err)
+		})
+		return fmt.Errorf("setting fan speed: %w", err)
+	}
+	d.status.update(func(s *status) { s.fanErr, s.speed, s.targetSpeed = nil, SafetySpeed, SafetySpeed })
+	d.metrics.fanSpeedSet.Inc()
+	d.metrics.fanSpeed.Set(SafetySpeed)
+	d.metrics.fanSpeedTarget.Set(SafetySpeed)
+
+	// Ensure the fan speed is reset when the daemon exits
+	defer func() {
+		// ctx is done already.
+		readCtx, cancel := context.WithTimeout(context.Background(), shutdownReadTimeout)
+		defer cancel()
+		last, err := d.reader.Read(readCtx)
+		if err != nil {
+			d.logger.Error("Reading temperature", "error", err)
+		}
+		d.logger.Warn("Fan control is shutting down, setting fan speed as a safety measure", "speed", SafetySpeed, "temperature", last.Temperature)
+		if err := d.fan.SetSpeed(SafetySpeed); err != nil {
+			d.logger.Error("Setting fan speed", "error", err)
+		}
+	}()
+
+	d.mu.Lock()
+	s := d.settings
+	d.mu.Unlock()
+
+	return d.control(ctx, s)
+}
+
+// loopState is the state the control loop carries from one reading to the next.
+type loopState struct {
+	// speed is the speed the fan was last set to, -1 if unknown.
+	speed       int
+	temperature float32
+	// failures is the number of consecutive failed readings.
+	failures int
+	// failSafe is set once the fail-safe speed is in effect.
+	// A reload may change the number of failures this takes.
+	failSafe bool
+	once     sync.Once
+}
+
+func (d *Daemon) control(ctx context.Context, s settings) error {
+	var (
+		st       = &loopState{speed: -1}
+		interval = s.interval
+		tick     = time.NewTicker(interval)
+	)
+	defer tick.Stop()
+	d.status.update(func(s *status) { s.interval = interval })
+
+	for {
+		select {
+		case s = <-d.reloadC:
+			interval = s.interval
+			tick.Reset(interval)
+			d.status.update(func(s *status) { s.interval = interval })
+			d.logger.Debug("Control loop picked up new settings")
+
+		case <-d.wakeC:
+			if _, err := d.iterate(ctx, s, st); err != nil {
+				return err
+			}
+
+		case <-tick.C:
+			sample, err := d.iterate(ctx, s, st)
+			if err != nil {
+				return err
+			}
+
+			if sample != nil && s.poller != nil {
+				if next := s.poller.Next(*sample); next != interval {
+					d.logger.Debug("Adjusting check interval", "temperature", sample.Temperature, "interval", next)
+					interval = next
+					tick.Reset(interval)
+					d.status.update(func(s *status) { s.interval = interval })
+				}
+			}
+
+		case <-ctx.Done():
+			d.logger.Debug("Received stop signal")
+			return nil
+		}
+	}
+}
+
+// iterate runs one iteration of the control loop, whether scheduled or woken up,
+// recording its duration and notifying the Notifier.
+func (d *Daemon) iterate(ctx context.Context, s settings, st *loopState) (*control.Sample, error) {
+	start := time.Now()
+	sample, err := d.step(ctx, s, st)
+	d.metrics.loopDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	d.notify(ctx, sample != nil)
+	return sample, nil
+}
+
+// step reads the temperature and sets the fan speed accordingly.
+// It returns the sample read, or nil if reading failed.
+// An error is returned only if the Daemon has to exit.
+func (d *Daemon) step(ctx context.Context, s settings, st *loopState) (*control.Sample, error) {
+	reading, err := d.reader.Read(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Shutting down, not a failure of the sensor.
+			return nil, nil
+		}
+		return nil, d.readFailed(s, st, err)
+	}
+	d.metrics.readings.Inc()
+
+	if st.failSafe {
+		d.logger.Info("Reading temperature recovered, resuming control", "failures", st.failures)
+		d.metrics.failSafe.Set(0)
+		st.failSafe = false
+	}
+	st.failures = 0
+	d.metrics.consecutiveFailures.Set(0)
+	d.status.update(func(s *status) {
+		s.temperature, s.lastReading = reading.Temperature, time.Now()
+		s.failures, s.failSafe = 0, false
+	})
+
+	st.temperature = reading.Temperature.Celsius()
+	d.metrics.temperatureK.Set(float64(reading.Temperature.Kelvin()))
+	sample := &control.Sample{Temperature: st.temperature, Time: reading.Time}
+	if sr, ok := d.reader.(SensorReader); ok {
+		sample.Sensors = sr.Readings()
+		d.status.update(func(s *status) { s.sensors = maps.Clone(sample.Sensors) })
+	}
+
+	var targetSpeed int
+	switch override, paused := d.manual(); {
+	case override != nil:
+		targetSpeed = override.Speed
+	case paused:
+		d.logger.Debug("Fan control paused", "temperature", st.temperature, "speed", st.speed)
+		targetSpeed = st.speed
+	default:
+		targetSpeed = s.controller.Speed(*sample, st.speed)
+		var state control.State
+		if sr, ok := s.controller.(control.StateReporter); ok {
+			state = sr.State()
+		}
+		threshold := 0.0
+		if state.Active {
+			threshold = float64(argononefan.FromCelsius(state.Threshold).Kelvin())
+		}
+		d.metrics.activeThresholdK.Set(threshold)
+		d.metrics.hysteresisHolding.Set(boolValue(state.Holding))
+		d.status.update(func(s *status) { s.control = state })
+	}
+	d.metrics.fanSpeedTarget.Set(float64(targetSpeed))
+	d.status.update(func(s *status) { s.targetSpeed = targetSpeed })
+
+	if targetSpeed == st.speed {
+		d.logger.Debug("Fan speed unchanged", "temperature", st.temperature, "speed", st.speed)
+		return sample, nil
+	}
+
+	d.logger.Debug("Adjusting fan speed", "temperature", st.temperature, "speed", targetSpeed)
+	if d.setSpeed(st, targetSpeed) {
+		st.once.Do(func() {
+			d.logger.Info("Set initial fan speed based on readings", "temperature", st.temperature, "speed", st.speed)
+		})
+	}
+	return sample, nil
+}
+
+// notify calls the Notifier, if any, after an iteration of the control loop.
+// The iteration was healthy if the temperature was read
+// and the last attempt to set the fan speed succeeded.
+func (d *Daemon) notify(ctx context.Context, read bool) {
+	if d.notifier == nil || ctx.Err() != nil {
+		// Without a Notifier or while shutting down, with the reading cancelled.
+		return
+	}
+	d.status.Lock()
+	healthy := read && d.status.fanErr == nil
+	d.status.Unlock()
+	d.notifier.Notify(d.Status(), healthy)
+}
+
+// readFailed applies the failure policy after reading the temperature failed with err.
+// It returns an error wrapping ErrSensorFailure if the Daemon has to exit.
+func (d *Daemon) readFailed(s settings, st *loopState, err error) error {
+	d.metrics.readings.Inc()
+	d.metrics.readingsFailed.Inc()
+	st.failures++
+	d.metrics.consecutiveFailures.Set(float64(st.failures))
+	d.status.update(func(s *status) {
+		s.failures = st.failures
+		s.failed(err)
+	})
+
+	if st.failures < s.maxFailures && !st.failSafe {
+		d.logger.Error("Reading temperature, keeping fan speed", "error", err, "failures", st.failures)
+		return nil
+	}
+	if st.failures >= s.maxFailures && !st.failSafe {
+		if s.exitOnFailure {
+			d.logger.Error("Reading temperature failed repeatedly, exiting", "error", err, "failures", st.failures)
+			return fmt.Errorf("%w: %d consecutive readings failed: %w", ErrSensorFailure, st.failures, err)
+		}
+		d.logger.Error("Reading temperature failed repeatedly, setting fail-safe speed", "error", err, "failures", st.failures, "speed", s.failSafeSpeed)
+		d.metrics.failSafe.Set(1)
+		st.failSafe = true
+	}
+
+	d.metrics.fanSpeedTarget.Set(float64(s.failSafeSpeed))
+	failSafeSpeed := s.failSafeSpeed
+	d.status.update(func(s *status) { s.failSafe, s.targetSpeed = true, failSafeSpeed })
+	if st.speed != s.failSafeSpeed {
+		d.setSpeed(st, s.failSafeSpeed)
+	}
+	return nil
+}
+
+// setSpeed sets the fan to speed and reports whether it succeeded.
+// Only then, speed becomes the current speed in st,
+// so that setting it is tried again with the next reading otherwise.
+func (d *Daemon) setSpeed(st *loopState, speed int) bool {
+	err := d.fan.SetSpeed(speed)
+	d.status.update(func(s *status) {
+		s.fanErr = err
+		if err != nil {
+			s.failed(err)
+		} else {
+			s.speed = speed
+		}
+	})
+	if err != nil {
+		d.logger.Error("Setting fan speed", "error", err, "speed", speed, "current", st.speed)
+		d.metrics.fanSpeedSetFailed.Inc()
+		return false
+	}
+	st.speed = speed
+	d.metrics.fanSpeed.Set(float64(speed))
+	d.metrics.fanSpeedSet.Inc()
+	return true
+}
@@ -0,0 +1,337 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+type fakeReader float32
+
+func (r fakeReader) Read(ctx context.Context) (argononefan.Reading, error) {
+	return argononefan.Reading{Temperature: argononefan.FromCelsius(float32(r)), Time: time.Now(), Source: "fake"}, nil
+}
+
+// flakyReader fails while broken is set.
+type flakyReader struct {
+	broken atomic.Bool
+}
+
+func (r *flakyReader) Read(ctx context.Context) (argononefan.Reading, error) {
+	if r.broken.Load() {
+		return argononefan.Reading{}, errors.New("sensor unplugged")
+	}
+	return fakeReader(40).Read(ctx)
+}
+
+type fakeFan struct {
+	sync.Mutex
+	speeds []int
+	// failures is the number of calls to SetSpeed failing before it succeeds again.
+	failures int
+}
+
+func (f *fakeFan) SetSpeed(speed int) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("remote I/O error")
+	}
+	f.speeds = append(f.speeds, speed)
+	return nil
+}
+
+func (f *fakeFan) Speeds() []int {
+	f.Lock()
+	defer f.Unlock()
+	return append([]int(nil), f.speeds...)
+}
+
+// fakeNotifier records the changes of health.
+type fakeNotifier struct {
+	sync.Mutex
+	changes []bool
+}
+
+func (n *fakeNotifier) Notify(_ Status, healthy bool) {
+	n.Lock()
+	defer n.Unlock()
+	if len(n.changes) == 0 || n.changes[len(n.changes)-1] != healthy {
+		n.changes = append(n.changes, healthy)
+	}
+}
+
+func (n *fakeNotifier) Changes() []bool {
+	n.Lock()
+	defer n.Unlock()
+	return slices.Clone(n.changes)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithFan(&fakeFan{}), WithController(control.ControllerFunc(func(control.Sample, int) int { return 0 })))
+	assert.ErrorIs(t, err, ErrNoReader)
+
+	_, err = New(WithReader(fakeReader(50)), WithFan(&fakeFan{}))
+	assert.ErrorIs(t, err, ErrNoController)
+
+	_, err = New(WithInterval(0))
+	assert.Error(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = New(WithReader(fakeReader(50)), WithFan(&fakeFan{}), WithController(control.ControllerFunc(func(control.Sample, int) int { return 0 })))
+		assert.NoError(t, err, "without a registry, the metrics must not be registered globally")
+	}
+}
+
+func TestRun(t *testing.T) {
+	fan := &fakeFan{}
+	d, err := New(
+		WithReader(fakeReader(65)),
+		WithFan(fan),
+		WithController(&control.ThresholdController{Thresholds: control.NewThresholds(map[float32]int{70: 100, 60: 50})}),
+		WithInterval(time.Millisecond),
+		WithRegistry(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, d.Ready(3), ErrNotReady, "not ready before the first reading")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	assert.Eventually(t, func() bool { return len(fan.Speeds()) >= 2 }, time.Second, time.Millisecond)
+	assert.NoError(t, d.Ready(1000))
+	assert.InDelta(t, 333.15, testutil.ToFloat64(d.metrics.activeThresholdK), 0.01)
+	st := d.Status()
+	assert.Equal(t, control.State{Threshold: 60, Active: true}, st.Control)
+	assert.NoError(t, st.LastError)
+	assert.False(t, st.Started.IsZero())
+	require.NoError(t, d.Reconfigure(WithController(control.ControllerFunc(func(control.Sample, int) int { return 10 }))))
+	assert.Eventually(t, func() bool { return len(fan.Speeds()) >= 3 }, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []int{SafetySpeed, 50, 10, SafetySpeed}, fan.Speeds(), "safety speed must be set on start and exit")
+
+	assert.InDelta(t, 338.15, testutil.ToFloat64(d.metrics.temperatureK), 0.01)
+	assert.Zero(t, testutil.ToFloat64(d.metrics.activeThresholdK), "the controller has no thresholds")
+	assert.GreaterOrEqual(t, testutil.ToFloat64(d.metrics.readings), 2.0)
+	assert.Zero(t, testutil.ToFloat64(d.metrics.readingsFailed))
+	assert.Equal(t, 10.0, testutil.ToFloat64(d.metrics.fanSpeedTarget))
+	assert.Equal(t, 1.0, testutil.ToFloat64(d.metrics.buildInfo.WithLabelValues("unknown", "unknown")))
+}
+
+func TestSensorFailure(t *testing.T) {
+	reader := &flakyReader{}
+	fan := &fakeFan{}
+	notifier := &fakeNotifier{}
+	d, err := New(
+		WithReader(reader),
+		WithFan(fan),
+		WithController(control.ControllerFunc(func(control.Sample, int) int { return 0 })),
+		WithInterval(time.Millisecond),
+		WithMaxFailures(2),
+		WithFailSafeSpeed(80),
+		WithRegistry(prometheus.NewRegistry()),
+		WithNotifier(notifier),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	speedsEventually := func(expected ...int) {
+		assert.Eventually(t, func() bool { return slices.Equal(fan.Speeds(), expected) }, time.Second, time.Millisecond)
+	}
+	speedsEventually(SafetySpeed, 0)
+	reader.broken.Store(true)
+	speedsEventually(SafetySpeed, 0, 80)
+	reader.broken.Store(false)
+	speedsEventually(SafetySpeed, 0, 80, 0)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.GreaterOrEqual(t, testutil.ToFloat64(d.metrics.readingsFailed), 2.0)
+	assert.Zero(t, testutil.ToFloat64(d.metrics.consecutiveFailures))
+	assert.Zero(t, testutil.ToFloat64(d.metrics.failSafe))
+	assert.EqualError(t, d.Status().LastError, "sensor unplugged", "the last error must be kept after recovering")
+	assert.Equal(t, []bool{true, false, true}, notifier.Changes(), "failed readings must be reported as unhealthy")
+
+	_, err = New(WithFailSafeSpeed(0))
+	assert.Error(t, err, "a broken sensor must never stop the fan")
+
+	reader.broken.Store(true)
+	require.NoError(t, d.Reconfigure(WithExitOnFailure(true)))
+	assert.ErrorIs(t, d.Run(context.Background()), ErrSensorFailure)
+}
+
+func TestSensorFailureReconfigured(t *testing.T) {
+	for _, exit := range []bool{false, true} {
+		reader := &flakyReader{}
+		reader.broken.Store(true)
+		fan := &fakeFan{}
+		d, err := New(
+			WithReader(reader),
+			WithFan(fan),
+			WithController(control.ControllerFunc(func(control.Sample, int) int { return 0 })),
+			WithInterval(time.Millisecond),
+			WithMaxFailures(1000),
+			WithFailSafeSpeed(80),
+			WithExitOnFailure(exit),
+			WithRegistry(prometheus.NewRegistry()),
+		)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- d.Run(ctx) }()
+
+		assert.Eventually(t, func() bool { return d.Status().Failures >= 3 }, time.Second, time.Millisecond)
+		// Fewer failures than have happened already.
+		require.NoError(t, d.Reconfigure(WithMaxFailures(2)))
+
+		if exit {
+			select {
+			case err := <-done:
+				assert.ErrorIs(t, err, ErrSensorFailure)
+			case <-time.After(time.Second):
+				t.Error("the daemon must exit once the failures exceed the new maximum")
+			}
+			cancel()
+			continue
+		}
+		assert.Eventually(t, func() bool { return slices.Contains(fan.Speeds(), 80) }, time.Second, time.Millisecond)
+		assert.Equal(t, 1.0, testutil.ToFloat64(d.metrics.failSafe), "the transition must not be missed")
+		cancel()
+		require.NoError(t, <-done)
+	}
+}
+
+func TestFanFailure(t *testing.T) {
+	fan := &fakeFan{}
+	var (
+		mu      sync.Mutex
+		current []int
+	)
+	d, err := New(
+		WithReader(fakeReader(50)),
+		WithFan(fan),
+		WithController(control.ControllerFunc(func(_ control.Sample, c int) int {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(current) == 0 {
+				// Fail the first two writes after the initial safety speed.
+				fan.Lock()
+				fan.failures = 2
+				fan.Unlock()
+			}
+			current = append(current, c)
+			return 30
+		})),
+		WithInterval(time.Millisecond),
+		WithRegistry(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(current) >= 4
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{-1, -1, -1, 30}, current[:4], "the speed must only be committed once it was set")
+	assert.Equal(t, []int{SafetySpeed, 30}, fan.Speeds()[:2])
+}
+
+func TestLoopDuration(t *testing.T) {
+	d, err := New(
+		WithReader(fakeReader(50)),
+		WithFan(&fakeFan{}),
+		WithController(control.ControllerFunc(func(control.Sample, int) int { return 10 })),
+		// No tick during the test: only woken iterations run.
+		WithInterval(time.Hour),
+		WithRegistry(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	require.NoError(t, d.SetOverride(50, 0))
+	assert.Eventually(t, func() bool {
+		m := &dto.Metric{}
+		require.NoError(t, d.metrics.loopDuration.Write(m))
+		return m.GetHistogram().GetSampleCount() == 1
+	}, time.Second, time.Millisecond, "woken iterations must be observed")
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestManual(t *testing.T) {
+	fan := &fakeFan{}
+	d, err := New(
+		WithReader(fakeReader(50)),
+		WithFan(fan),
+		WithController(control.ControllerFunc(func(control.Sample, int) int { return 10 })),
+		WithInterval(time.Millisecond),
+		WithRegistry(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, -1, d.Status().Speed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	speedEventually := func(speed int) {
+		assert.Eventually(t, func() bool { return d.Status().Speed == speed }, time.Second, time.Millisecond)
+	}
+	speedEventually(10)
+
+	assert.Error(t, d.SetOverride(101, 0))
+	require.NoError(t, d.SetOverride(100, 0))
+	speedEventually(100)
+	assert.Equal(t, &Override{Speed: 100}, d.Status().Override)
+
+	d.Pause()
+	d.ClearOverride()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 100, d.Status().Speed, "the speed must be kept while paused")
+	assert.True(t, d.Status().Paused)
+
+	d.Resume()
+	speedEventually(10)
+
+	require.NoError(t, d.SetOverride(50, 20*time.Millisecond))
+	speedEventually(50)
+	speedEventually(10)
+	assert.Nil(t, d.Status().Override, "the override must expire")
+
+	cancel()
+	require.NoError(t, <-done)
+}
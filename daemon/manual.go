@@ -0,0 +1,115 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  manual.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+	"time"
+)
+
+// Override is a fan speed set manually, taking precedence over the control.Controller.
+type Override struct {
+	// Speed is the fan speed in percent.
+	Speed int
+	// Until is the time the override expires at, zero if it does not expire.
+	Until time.Time
+}
+
+// SetOverride sets the fan to speed, regardless of the temperature,
+// until ClearOverride is called or ttl has passed. With a ttl of 0,
+// the override does not expire. It takes effect immediately.
+//
+// If the temperature can not be read, the fail-safe speed
+// still takes precedence over the override.
+func (d *Daemon) SetOverride(speed int, ttl time.Duration) error {
+	if speed < 0 || speed > 100 {
+		return fmt.Errorf("fan speed is out of range: %d", speed)
+	}
+	if ttl < 0 {
+		return fmt.Errorf("ttl must not be negative: %s", ttl)
+	}
+
+	o := &Override{Speed: speed}
+	if ttl > 0 {
+		o.Until = time.Now().Add(ttl)
+	}
+
+	d.mu.Lock()
+	d.override = o
+	d.mu.Unlock()
+	d.logger.Info("Overriding fan speed", "speed", speed, "ttl", ttl)
+	d.wake()
+	return nil
+}
+
+// ClearOverride removes the override set with SetOverride.
+func (d *Daemon) ClearOverride() {
+	d.mu.Lock()
+	d.override = nil
+	d.mu.Unlock()
+	d.logger.Info("Cleared fan speed override")
+	d.wake()
+}
+
+// Pause stops the Daemon from changing the fan speed until Resume is called.
+// The temperature is still read, an override and the fail-safe speed still apply.
+func (d *Daemon) Pause() {
+	d.mu.Lock()
+	d.paused = true
+	d.mu.Unlock()
+	d.logger.Info("Paused fan control")
+}
+
+// Resume lets the Daemon control the fan speed again after Pause.
+func (d *Daemon) Resume() {
+	d.mu.Lock()
+	d.paused = false
+	d.mu.Unlock()
+	d.logger.Info("Resumed fan control")
+	d.wake()
+}
+
+// manual returns a copy of the current override, if any, and whether the Daemon is paused.
+// An expired override is removed.
+func (d *Daemon) manual() (*Override, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.override == nil {
+		return nil, d.paused
+	}
+	if !d.override.Until.IsZero() && time.Now().After(d.override.Until) {
+		d.logger.Info("Fan speed override expired", "speed", d.override.Speed)
+		d.override = nil
+		return nil, d.paused
+	}
+	o := *d.override
+	return &o, d.paused
+}
+
+// wake makes the control loop apply changes immediately
+// instead of waiting for the next reading.
+func (d *Daemon) wake() {
+	select {
+	case d.wakeC <- struct{}{}:
+	default:
+		// A wake up is pending already.
+	}
+}
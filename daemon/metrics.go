@@ -0,0 +1,141 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  metrics.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are the Prometheus metrics of a Daemon.
+type metrics struct {
+	readings            prometheus.Counter
+	readingsFailed      prometheus.Counter
+	consecutiveFailures prometheus.Gauge
+	temperatureK        prometheus.Gauge
+	activeThresholdK    prometheus.Gauge
+	hysteresisHolding   prometheus.Gauge
+	fanSpeed            prometheus.Gauge
+	fanSpeedTarget      prometheus.Gauge
+	fanSpeedSet         prometheus.Counter
+	fanSpeedSetFailed   prometheus.Counter
+	failSafe            prometheus.Gauge
+	loopDuration        prometheus.Histogram
+	buildInfo           *prometheus.GaugeVec
+}
+
+// newMetrics creates the metrics and registers them with reg, if it is not nil.
+// version and model are exposed as labels of the build info.
+func newMetrics(reg prometheus.Registerer, version, model string) (*metrics, error) {
+	m := &metrics{
+		readings: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:      "temperature_readings_total",
+			Help:      "The total number of temperature readings performed by argononefan in daemon mode",
+			Subsystem: "argonone",
+		}),
+		readingsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:      "temperature_readings_failed_total",
+			Help:      "The total number of failed temperature readings performed by argononefan in daemon mode",
+			Subsystem: "argonone",
+		}),
+		consecutiveFailures: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "temperature_readings_consecutive_failures",
+			Help:      "The number of temperature readings that failed in a row",
+			Subsystem: "argonone",
+		}),
+		temperatureK: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "temperature",
+			Help:      "The current CPU temperature in degrees Kelvin",
+			Subsystem: "argonone",
+		}),
+		activeThresholdK: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "active_threshold",
+			Help:      "The temperature of the threshold in effect in degrees Kelvin, 0 if the temperature is below all thresholds or the controller has none",
+			Subsystem: "argonone",
+		}),
+		hysteresisHolding: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "hysteresis_holding",
+			Help:      "1 while the hysteresis keeps the fan from slowing down, 0 otherwise",
+			Subsystem: "argonone",
+		}),
+		fanSpeed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "fan_speed",
+			Help:      "The current fan speed in percent",
+			Subsystem: "argonone",
+		}),
+		fanSpeedTarget: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "fan_speed_target",
+			Help:      "The fan speed in percent demanded by the controller, which differs from fan_speed while setting it fails",
+			Subsystem: "argonone",
+		}),
+		fanSpeedSet: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:      "speed_set_total",
+			Help:      "The total number of fan speed changes performed by argononefan in daemon mode",
+			Subsystem: "argonone",
+		}),
+		fanSpeedSetFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:      "fan_speed_set_failed_total",
+			Help:      "The total number of failed fan speed changes performed by argononefan in daemon mode",
+			Subsystem: "argonone",
+		}),
+		failSafe: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      "fail_safe",
+			Help:      "1 while the fan runs at the fail-safe speed because the temperature can not be read, 0 otherwise",
+			Subsystem: "argonone",
+		}),
+		loopDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:      "control_loop_duration_seconds",
+			Help:      "The time an iteration of the control loop takes to read the temperature and set the fan speed",
+			Subsystem: "argonone",
+			// 1ms to 2s
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+		}),
+		buildInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:      "build_info",
+			Help:      "Always 1, labeled with the version of argononefan and the model of the case",
+			Subsystem: "argonone",
+		}, []string{"version", "model"}),
+	}
+	m.buildInfo.WithLabelValues(version, model).Set(1)
+
+	if reg == nil {
+		return m, nil
+	}
+	for _, c := range []prometheus.Collector{
+		m.readings, m.readingsFailed, m.consecutiveFailures,
+		m.temperatureK, m.activeThresholdK, m.hysteresisHolding,
+		m.fanSpeed, m.fanSpeedTarget, m.fanSpeedSet, m.fanSpeedSetFailed,
+		m.failSafe, m.loopDuration, m.buildInfo,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("registering metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// boolValue returns 1 if b is set, 0 otherwise.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
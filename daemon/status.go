@@ -0,0 +1,149 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  status.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mwmahlberg/argononefan"
+	"github.com/mwmahlberg/argononefan/control"
+	"golang.org/x/exp/maps"
+)
+
+// ErrNotReady is wrapped by the errors returned by Ready.
+var ErrNotReady = errors.New("daemon is not ready")
+
+// Status is a snapshot of the state of a Daemon.
+type Status struct {
+	// Temperature is the temperature read last.
+	Temperature argononefan.Temperature
+	// Sensors are the readings in Celsius of the individual sensors by name,
+	// nil if the TemperatureReader is no SensorReader.
+	Sensors map[string]float32
+	// LastReading is the time the temperature was last read successfully,
+	// zero if it was not read yet.
+	LastReading time.Time
+	// Speed is the speed the fan was last set to, -1 if it is unknown.
+	Speed int
+	// TargetSpeed is the speed the fan should run at.
+	// It differs from Speed while setting the fan speed fails.
+	TargetSpeed int
+	// Interval is the current interval between two readings.
+	Interval time.Duration
+	// Failures is the number of consecutive failed readings.
+	Failures int
+	// FailSafe is set while the fan runs at the fail-safe speed.
+	FailSafe bool
+	// Paused is set while the control loop is paused.
+	Paused bool
+	// Override is the speed set manually, nil if there is none.
+	Override *Override
+	// Control is the state the control.Controller reported with its last decision,
+	// zero if it is no control.StateReporter.
+	Control control.State
+	// LastError is the error of the last failed reading or attempt to set
+	// the fan speed, nil if there was none. It is kept after recovering.
+	LastError error
+	// LastErrorTime is the time LastError occurred at.
+	LastErrorTime time.Time
+	// Started is the time Run was called at, zero if it was not called yet.
+	Started time.Time
+}
+
+// status is what the control loop reports about itself
+// for observers outside of it.
+type status struct {
+	sync.Mutex
+	temperature argononefan.Temperature
+	sensors     map[string]float32
+	// lastReading is the time the temperature was last read successfully.
+	lastReading time.Time
+	speed       int
+	targetSpeed int
+	// interval is the current interval between two readings.
+	interval time.Duration
+	failures int
+	failSafe bool
+	// fanErr is the error of the last attempt to set the fan speed, if it failed.
+	fanErr  error
+	control control.State
+	// lastErr is the last error reading the temperature or setting the fan speed.
+	lastErr     error
+	lastErrTime time.Time
+	started     time.Time
+}
+
+// failed records err as the last error.
+func (s *status) failed(err error) {
+	s.lastErr, s.lastErrTime = err, time.Now()
+}
+
+// update calls f with the status locked.
+func (s *status) update(f func(s *status)) {
+	s.Lock()
+	defer s.Unlock()
+	f(s)
+}
+
+// Status returns the current Status of d.
+func (d *Daemon) Status() Status {
+	override, paused := d.manual()
+
+	d.status.Lock()
+	defer d.status.Unlock()
+	return Status{
+		Temperature:   d.status.temperature,
+		Sensors:       maps.Clone(d.status.sensors),
+		LastReading:   d.status.lastReading,
+		Speed:         d.status.speed,
+		TargetSpeed:   d.status.targetSpeed,
+		Interval:      d.status.interval,
+		Failures:      d.status.failures,
+		FailSafe:      d.status.failSafe,
+		Paused:        paused,
+		Override:      override,
+		Control:       d.status.control,
+		LastError:     d.status.lastErr,
+		LastErrorTime: d.status.lastErrTime,
+		Started:       d.status.started,
+	}
+}
+
+// Ready returns nil if the temperature was read successfully within the last
+// intervals check intervals and the last attempt to set the fan speed succeeded.
+// Otherwise, it returns an error wrapping ErrNotReady which tells why.
+func (d *Daemon) Ready(intervals int) error {
+	d.status.Lock()
+	defer d.status.Unlock()
+
+	window := time.Duration(intervals) * d.status.interval
+	switch {
+	case d.status.lastReading.IsZero():
+		return fmt.Errorf("%w: temperature not read yet", ErrNotReady)
+	case time.Since(d.status.lastReading) > window:
+		return fmt.Errorf("%w: temperature not read for %s", ErrNotReady, time.Since(d.status.lastReading).Round(time.Second))
+	case d.status.fanErr != nil:
+		return fmt.Errorf("%w: setting fan speed: %w", ErrNotReady, d.status.fanErr)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  discover.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// DefaultSysfsRoot is the mount point of sysfs.
+const DefaultSysfsRoot = "/sys"
+
+// SensorKind is the kernel interface a sensor is exposed by.
+type SensorKind string
+
+const (
+	// SensorThermalZone is a zone of the thermal framework, /sys/class/thermal/thermal_zone*.
+	SensorThermalZone SensorKind = "thermal"
+	// SensorHwmon is a temperature input of a hardware monitor, /sys/class/hwmon/hwmon*/temp*_input.
+	SensorHwmon SensorKind = "hwmon"
+)
+
+// Unit is the unit of the readings of a sensor.
+type Unit string
+
+const (
+	// UnitMilliCelsius is thousandths of a degree Celsius,
+	// used by both thermal zones and hwmon temperature inputs.
+	UnitMilliCelsius Unit = "m°C"
+	// UnitCelsius is plain degrees Celsius, possibly with decimals.
+	UnitCelsius Unit = "°C"
+)
+
+// SensorInfo describes a temperature sensor found by DiscoverSensors.
+type SensorInfo struct {
+	// ID identifies the sensor, for example thermal_zone0 or hwmon1/temp1.
+	ID string
+	// Kind is the kernel interface the sensor is exposed by.
+	Kind SensorKind
+	// Type is the type of a thermal zone, like cpu-thermal,
+	// or the name of a hardware monitor, like nvme.
+	Type string
+	// Label is the label of a hwmon input, like Composite, if any.
+	Label string
+	// Path is the file containing the readings.
+	// It can be used with WithThermalDeviceFile.
+	Path string
+	// Unit is the unit of the readings in Path.
+	Unit Unit
+}
+
+// DiscoverOption is a function that configures DiscoverSensors.
+type DiscoverOption func(*discoverer) error
+
+type discoverer struct {
+	root string
+}
+
+// WithSysfsRoot is an option that sets the directory sysfs is mounted at.
+// The default is DefaultSysfsRoot.
+func WithSysfsRoot(root string) DiscoverOption {
+	return func(d *discoverer) error {
+		d.root = root
+		return nil
+	}
+}
+
+// DiscoverSensors returns the thermal zones and hwmon temperature inputs
+// available, ordered by kind and ID.
+func DiscoverSensors(opts ...DiscoverOption) ([]SensorInfo, error) {
+	d := &discoverer{root: DefaultSysfsRoot}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, fmt.Errorf("discovering sensors: %w", err)
+		}
+	}
+
+	zones, err := d.thermalZones()
+	if err != nil {
+		return nil, fmt.Errorf("discovering thermal zones: %w", err)
+	}
+	inputs, err := d.hwmonInputs()
+	if err != nil {
+		return nil, fmt.Errorf("discovering hwmon sensors: %w", err)
+	}
+	return append(zones, inputs...), nil
+}
+
+func (d *discoverer) thermalZones() ([]SensorInfo, error) {
+	dirs, err := filepath.Glob(filepath.Join(d.root, "class", "thermal", "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(dirs, naturalCompare)
+
+	var sensors []SensorInfo
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "temp")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		sensors = append(sensors, SensorInfo{
+			ID:   filepath.Base(dir),
+			Kind: SensorThermalZone,
+			Type: readAttribute(filepath.Join(dir, "type")),
+			Path: path,
+			Unit: UnitMilliCelsius,
+		})
+	}
+	return sensors, nil
+}
+
+func (d *discoverer) hwmonInputs() ([]SensorInfo, error) {
+	dirs, err := filepath.Glob(filepath.Join(d.root, "class", "hwmon", "hwmon*"))
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(dirs, naturalCompare)
+
+	var sensors []SensorInfo
+	for _, dir := range dirs {
+		inputs, err := filepath.Glob(filepath.Join(dir, "temp*_input"))
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(inputs, naturalCompare)
+
+		name := readAttribute(filepath.Join(dir, "name"))
+		for _, input := range inputs {
+			channel := strings.TrimSuffix(filepath.Base(input), "_input")
+			sensors = append(sensors, SensorInfo{
+				ID:    filepath.Base(dir) + "/" + channel,
+				Kind:  SensorHwmon,
+				Type:  name,
+				Label: readAttribute(filepath.Join(dir, channel+"_label")),
+				Path:  input,
+				Unit:  UnitMilliCelsius,
+			})
+		}
+	}
+	return sensors, nil
+}
+
+// readAttribute returns the trimmed content of a sysfs attribute,
+// or an empty string if it can not be read.
+func readAttribute(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// naturalCompare orders the paths matched by a single glob numerically,
+// so that thermal_zone10 comes after thermal_zone9. As they only differ
+// in their numbers, the shorter path has the lower number.
+func naturalCompare(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
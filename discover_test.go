@@ -0,0 +1,47 @@
+package argononefan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSysfs(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestDiscoverSensors(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"class/thermal/thermal_zone0/type":   "cpu-thermal\n",
+		"class/thermal/thermal_zone0/temp":   "48500\n",
+		"class/thermal/thermal_zone10/type":  "gpu-thermal\n",
+		"class/thermal/thermal_zone10/temp":  "41000\n",
+		"class/thermal/cooling_device0/type": "pwm-fan\n",
+		"class/hwmon/hwmon1/name":            "nvme\n",
+		"class/hwmon/hwmon1/temp1_input":     "38850\n",
+		"class/hwmon/hwmon1/temp1_label":     "Composite\n",
+		"class/hwmon/hwmon1/temp2_input":     "40850\n",
+		"class/hwmon/hwmon1/fan1_input":      "1200\n",
+	})
+
+	sensors, err := DiscoverSensors(WithSysfsRoot(root))
+	require.NoError(t, err)
+	assert.Equal(t, []SensorInfo{
+		{ID: "thermal_zone0", Kind: SensorThermalZone, Type: "cpu-thermal", Path: filepath.Join(root, "class/thermal/thermal_zone0/temp"), Unit: UnitMilliCelsius},
+		{ID: "thermal_zone10", Kind: SensorThermalZone, Type: "gpu-thermal", Path: filepath.Join(root, "class/thermal/thermal_zone10/temp"), Unit: UnitMilliCelsius},
+		{ID: "hwmon1/temp1", Kind: SensorHwmon, Type: "nvme", Label: "Composite", Path: filepath.Join(root, "class/hwmon/hwmon1/temp1_input"), Unit: UnitMilliCelsius},
+		{ID: "hwmon1/temp2", Kind: SensorHwmon, Type: "nvme", Path: filepath.Join(root, "class/hwmon/hwmon1/temp2_input"), Unit: UnitMilliCelsius},
+	}, sensors)
+
+	sensors, err = DiscoverSensors(WithSysfsRoot(t.TempDir()))
+	require.NoError(t, err)
+	assert.Empty(t, sensors)
+}
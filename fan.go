@@ -1,28 +1,29 @@
-/* 
+/*
  *  Copyright 2024 Markus W Mahlberg
- *  
+ *
  *  fan.go is part of argononefan
- *  
+ *
  *  Licensed under the Apache License, Version 2.0 (the "License");
  *  you may not use this file except in compliance with the License.
  *  You may obtain a copy of the License at
- *  
+ *
  *      http://www.apache.org/licenses/LICENSE-2.0
- *  
+ *
  *  Unless required by applicable law or agreed to in writing, software
  *  distributed under the License is distributed on an "AS IS" BASIS,
  *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
  *  See the License for the specific language governing permissions and
  *  limitations under the License.
- *  
+ *
  */
 
 package argononefan
 
 import (
+	"errors"
 	"fmt"
-
-	"gobot.io/x/gobot/platforms/raspi"
+	"sync"
+	"time"
 )
 
 // DefaultFanAddress is the default address of the fan on the i2c bus
@@ -31,21 +32,92 @@ import (
 // However it can be overridden using the WithAddress option.
 const DefaultFanAddress = 0x1A
 
+const (
+	// DefaultMinReconnectBackoff is the time to wait before the second
+	// attempt to reconnect to the fan after a bus error.
+	DefaultMinReconnectBackoff = 500 * time.Millisecond
+	// DefaultMaxReconnectBackoff is the maximum time to wait between
+	// two attempts to reconnect to the fan.
+	DefaultMaxReconnectBackoff = 30 * time.Second
+)
+
+const (
+	// DefaultRetries is the number of times an operation on the fan
+	// is retried after a bus error.
+	DefaultRetries = 2
+	// DefaultMinRetryBackoff is the time to wait before the first retry.
+	DefaultMinRetryBackoff = 20 * time.Millisecond
+	// DefaultMaxRetryBackoff is the maximum time to wait between two retries.
+	DefaultMaxRetryBackoff = 200 * time.Millisecond
+)
+
+var (
+	// ErrFanClosed is returned when using a Fan after Close was called.
+	ErrFanClosed = errors.New("fan connection is closed")
+	// ErrReconnectBackoff is returned when a reconnect to the fan is due,
+	// but the backoff time since the last failed attempt has not passed yet.
+	ErrReconnectBackoff = errors.New("waiting to reconnect to fan")
+	// ErrSpeedUnknown is returned by Speed if the speed can not be read
+	// from the controller and was not set using this Fan yet.
+	ErrSpeedUnknown = errors.New("fan speed is unknown")
+	// ErrVerifyFailed is returned by SetSpeed if the speed read back
+	// from the controller differs from the speed written.
+	ErrVerifyFailed = errors.New("fan speed read back differs from speed written")
+)
+
 // FanOption is a function that configures a Fan instance.
 type FanOption func(*Fan) error
 
 // Fan is a type that represents the fan in the ArgonOne case.
+// It holds an open connection to the i2c bus until Close is called.
+// A Fan is safe for concurrent use.
 type Fan struct {
+	mu      sync.Mutex
 	bus     int
 	address int
+	i2c     I2CBus
+	model   Model
+	proto   protocol
+	// boardModelFile is read to determine the model with ModelAuto.
+	boardModelFile string
+
+	speed       int
+	connected   bool
+	closed      bool
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	backoff     time.Duration
+	nextAttempt time.Time
+
+	retries         int
+	minRetryBackoff time.Duration
+	maxRetryBackoff time.Duration
+	verify          bool
 }
 
 // Connect opens a connection to the fan on bus 0 at address 0x1A.
-// Those values can be overridden using the WithBus and WithAddress options.
+// Those values can be overridden using the OnBus and WithAddress options.
+// The fan is driven via the i2c character devices of the kernel,
+// unless a different transport is set with the WithI2CBus option.
+//
+// The connection is kept open until Close is called.
+// If the bus reports an error, the fan transparently reconnects,
+// backing off exponentially while reconnecting fails.
 func Connect(opts ...FanOption) (*Fan, error) {
 	f := &Fan{
 		bus:     0,
 		address: DefaultFanAddress,
+		i2c:     NewDevI2CBus(),
+		model:   ArgonOneV2,
+		speed:   -1,
+
+		boardModelFile: DefaultBoardModelFile,
+		minBackoff:     DefaultMinReconnectBackoff,
+		maxBackoff:     DefaultMaxReconnectBackoff,
+
+		retries:         DefaultRetries,
+		minRetryBackoff: DefaultMinRetryBackoff,
+		maxRetryBackoff: DefaultMaxRetryBackoff,
 	}
 
 	for _, opt := range opts {
@@ -54,6 +126,20 @@ func Connect(opts ...FanOption) (*Fan, error) {
 		}
 	}
 
+	if f.model == ModelAuto {
+		m, err := probeModel(f.boardModelFile)
+		if err != nil {
+			return nil, fmt.Errorf("error creating fan: %w", err)
+		}
+		f.model = m
+	}
+
+	if err := f.i2c.Open(f.bus, f.address); err != nil {
+		return nil, fmt.Errorf("can't connect to i2c bus: %w", err)
+	}
+	f.connected = true
+	f.proto = protocolFor(f.model)
+
 	return f, nil
 }
 
@@ -77,26 +163,222 @@ func WithAddress(address int) FanOption {
 	}
 }
 
-// SetSpeed sets the fan speed.
+// WithReconnectBackoff is an option that sets the bounds of the exponential backoff
+// applied between attempts to reconnect to the fan after a bus error.
+func WithReconnectBackoff(min, max time.Duration) FanOption {
+	return func(f *Fan) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("invalid reconnect backoff: min %s, max %s", min, max)
+		}
+		f.minBackoff, f.maxBackoff = min, max
+		return nil
+	}
+}
+
+// WithRetries is an option that sets the number of times an operation
+// on the fan is retried after a bus error, reconnecting before each retry.
+// With 0, operations are not retried.
+func WithRetries(retries int) FanOption {
+	return func(f *Fan) error {
+		if retries < 0 {
+			return fmt.Errorf("number of retries must not be negative: %d", retries)
+		}
+		f.retries = retries
+		return nil
+	}
+}
+
+// WithRetryBackoff is an option that sets the bounds of the exponential backoff
+// applied between retries of an operation on the fan.
+func WithRetryBackoff(min, max time.Duration) FanOption {
+	return func(f *Fan) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("invalid retry backoff: min %s, max %s", min, max)
+		}
+		f.minRetryBackoff, f.maxRetryBackoff = min, max
+		return nil
+	}
+}
+
+// WithVerify is an option that makes SetSpeed read the speed back
+// from the controller after writing it and retry if it differs.
+// It has no effect on models which can not read the fan speed,
+// like the original ArgonOne case.
+func WithVerify(verify bool) FanOption {
+	return func(f *Fan) error {
+		f.verify = verify
+		return nil
+	}
+}
+
+// SetSpeed sets the fan speed. If the speed was not set after all retries,
+// an error is returned, wrapping ErrVerifyFailed if the controller
+// reported a different speed.
 func (f *Fan) SetSpeed(speed int) error {
 
 	if speed < 0 || speed > 100 {
 		return fmt.Errorf("desired fan speed is out of range: %d", speed)
 	}
 
-	a := raspi.NewAdaptor()
-	defer a.Finalize()
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	conn, err := a.GetConnection(f.address, f.bus)
+	err := f.withRetry(func() error {
+		if err := f.proto.writeSpeed(f.i2c, speed); err != nil {
+			return fmt.Errorf("can't write fan speed: %w", err)
+		}
+		if !f.verify || !f.proto.canReadSpeed() {
+			return nil
+		}
+		actual, err := f.proto.readSpeed(f.i2c)
+		if err != nil {
+			return fmt.Errorf("can't read back fan speed: %w", err)
+		}
+		if actual != speed {
+			return fmt.Errorf("%w: wrote %d, read %d", ErrVerifyFailed, speed, actual)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("can't connect to i2c bus: %w", err)
+		return err
 	}
-	defer conn.Close()
 
-	err = conn.WriteByte(byte(speed))
+	f.speed = speed
+	return nil
+}
+
+// Speed returns the current fan speed.
+//
+// If the model supports it, the speed is read from the controller.
+// Otherwise, as with the original ArgonOne case, the last speed
+// successfully set using this Fan is returned and cached is true.
+// If no speed was set yet, ErrSpeedUnknown is returned.
+func (f *Fan) Speed() (speed int, cached bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, false, ErrFanClosed
+	}
+
+	if !f.proto.canReadSpeed() {
+		if f.speed < 0 {
+			return 0, true, ErrSpeedUnknown
+		}
+		return f.speed, true, nil
+	}
+
+	err = f.withRetry(func() (rerr error) {
+		speed, rerr = f.proto.readSpeed(f.i2c)
+		return rerr
+	})
 	if err != nil {
-		return fmt.Errorf("can't write fan seed: %W", err)
+		return 0, false, fmt.Errorf("can't read fan speed: %w", err)
+	}
+
+	f.speed = speed
+	return speed, false, nil
+}
+
+// SignalPowerOff tells the controller of the case to cut the power
+// once the Pi has halted. It is meant to be called as late as possible
+// during a shutdown, as the power is cut a few seconds after the signal.
+func (f *Fan) SignalPowerOff() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.withRetry(func() error {
+		if err := f.proto.signalPowerOff(f.i2c); err != nil {
+			return fmt.Errorf("can't signal power off: %w", err)
+		}
+		return nil
+	})
+}
+
+// Model returns the model of the ArgonOne case the fan is in.
+// If the fan was connected using ModelAuto, this is the model determined from the board.
+func (f *Fan) Model() Model {
+	return f.model
+}
+
+// Close closes the connection to the fan.
+// The fan keeps running at the last speed set.
+func (f *Fan) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	f.connected = false
+	return f.i2c.Close()
+}
+
+// withRetry runs op on an established connection.
+// If op fails, the connection is reestablished and op is retried
+// up to f.retries times, backing off exponentially in between.
+// f.mu must be held by the caller.
+func (f *Fan) withRetry(op func() error) error {
+	if err := f.reconnect(); err != nil {
+		return err
+	}
+
+	var errs []error
+	backoff := f.minRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+		f.disconnect()
+		if attempt == f.retries {
+			return errors.Join(errs...)
+		}
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, f.maxRetryBackoff)
+		if err := f.reconnect(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+}
+
+// reconnect opens the bus if the fan is not connected,
+// honoring the backoff after failed attempts.
+// f.mu must be held by the caller.
+func (f *Fan) reconnect() error {
+	if f.closed {
+		return ErrFanClosed
+	}
+	if f.connected {
+		return nil
+	}
+
+	if now := time.Now(); now.Before(f.nextAttempt) {
+		return fmt.Errorf("%w: next attempt in %s", ErrReconnectBackoff, f.nextAttempt.Sub(now).Round(time.Millisecond))
 	}
 
+	if err := f.i2c.Open(f.bus, f.address); err != nil {
+		if f.backoff == 0 {
+			f.backoff = f.minBackoff
+		} else if f.backoff *= 2; f.backoff > f.maxBackoff {
+			f.backoff = f.maxBackoff
+		}
+		f.nextAttempt = time.Now().Add(f.backoff)
+		return fmt.Errorf("can't reconnect to i2c bus: %w", err)
+	}
+
+	f.connected = true
+	f.backoff = 0
+	f.nextAttempt = time.Time{}
 	return nil
 }
+
+// disconnect closes the bus after an error.
+// f.mu must be held by the caller.
+func (f *Fan) disconnect() {
+	f.i2c.Close()
+	f.connected = false
+}
@@ -0,0 +1,162 @@
+package argononefan
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSpeed(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	fan, err := Connect(OnBus(1), WithI2CBus(bus))
+	require.NoError(t, err)
+	assert.True(t, bus.IsOpen())
+
+	require.NoError(t, fan.SetSpeed(50))
+	b, a := bus.Device()
+	assert.Equal(t, 1, b)
+	assert.Equal(t, DefaultFanAddress, a)
+	assert.Equal(t, [][]byte{{50}}, bus.Writes())
+	assert.True(t, bus.IsOpen(), "connection must be kept open")
+
+	assert.Error(t, fan.SetSpeed(101))
+	assert.Error(t, fan.SetSpeed(-1))
+	assert.Len(t, bus.Writes(), 1)
+
+	require.NoError(t, fan.Close())
+	assert.False(t, bus.IsOpen())
+	assert.ErrorIs(t, fan.SetSpeed(10), ErrFanClosed)
+}
+
+func TestConnectBusError(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	busErr := errors.New("no such device")
+	bus.FailNext(busErr)
+
+	_, err := Connect(WithI2CBus(bus))
+	assert.ErrorIs(t, err, busErr)
+}
+
+func TestSetSpeedReconnects(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	fan, err := Connect(WithI2CBus(bus), WithReconnectBackoff(time.Hour, time.Hour))
+	require.NoError(t, err)
+
+	bus.FailNext(errors.New("remote I/O error"))
+	require.NoError(t, fan.SetSpeed(10), "a single bus error must be handled transparently")
+	assert.Equal(t, [][]byte{{10}}, bus.Writes())
+
+	busErr := errors.New("no such device")
+	bus.SetError(busErr)
+	assert.ErrorIs(t, fan.SetSpeed(20), busErr)
+
+	bus.SetError(nil)
+	assert.ErrorIs(t, fan.SetSpeed(20), ErrReconnectBackoff)
+	assert.Len(t, bus.Writes(), 1)
+}
+
+func TestSetSpeedRetries(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	fan, err := Connect(WithI2CBus(bus), WithRetries(0))
+	require.NoError(t, err)
+
+	busErr := errors.New("remote I/O error")
+	bus.FailNext(busErr)
+	assert.ErrorIs(t, fan.SetSpeed(10), busErr, "errors must not be retried with 0 retries")
+	assert.Empty(t, bus.Writes())
+
+	_, err = Connect(WithI2CBus(bus), WithRetries(-1))
+	assert.Error(t, err)
+	_, err = Connect(WithI2CBus(bus), WithRetryBackoff(time.Second, time.Millisecond))
+	assert.Error(t, err)
+}
+
+func TestSetSpeedVerify(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	fan, err := Connect(WithI2CBus(bus), WithModel(ArgonOneV3), WithVerify(true), WithRetryBackoff(time.Millisecond, time.Millisecond))
+	require.NoError(t, err)
+
+	// The first write is not taken by the controller.
+	bus.QueueRead(0, 40)
+	require.NoError(t, fan.SetSpeed(40))
+	assert.Equal(t, [][]byte{
+		{registerFanSpeed, 40}, {registerFanSpeed},
+		{registerFanSpeed, 40}, {registerFanSpeed},
+	}, bus.Writes())
+
+	bus.QueueRead(0, 0, 0)
+	assert.ErrorIs(t, fan.SetSpeed(60), ErrVerifyFailed)
+	assert.Len(t, bus.Writes(), 10, "the write must be retried DefaultRetries times")
+}
+
+func TestSpeed(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	fan, err := Connect(WithI2CBus(bus))
+	require.NoError(t, err)
+
+	_, _, err = fan.Speed()
+	assert.ErrorIs(t, err, ErrSpeedUnknown)
+
+	require.NoError(t, fan.SetSpeed(30))
+	bus.SetError(errors.New("remote I/O error"))
+	assert.Error(t, fan.SetSpeed(60))
+
+	speed, cached, err := fan.Speed()
+	require.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, 30, speed, "a failed write must not change the cached speed")
+}
+
+func TestModelV3(t *testing.T) {
+	bus := NewMemoryI2CBus()
+	fan, err := Connect(WithI2CBus(bus), WithModel(ArgonOneV3))
+	require.NoError(t, err)
+
+	require.NoError(t, fan.SetSpeed(40))
+	assert.Equal(t, [][]byte{{registerFanSpeed, 40}}, bus.Writes())
+
+	bus.QueueRead(40)
+	speed, cached, err := fan.Speed()
+	require.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 40, speed)
+}
+
+func TestModelAuto(t *testing.T) {
+	dir := t.TempDir()
+	for board, expected := range map[string]Model{
+		"Raspberry Pi 5 Model B Rev 1.0\x00": ArgonOneV3,
+		"Raspberry Pi 4 Model B Rev 1.4\x00": ArgonOneV2,
+	} {
+		path := filepath.Join(dir, "model")
+		require.NoError(t, os.WriteFile(path, []byte(board), 0o644))
+		bus := NewMemoryI2CBus()
+		// Like the V3, the original controller might answer a read.
+		bus.QueueRead(50)
+		fan, err := Connect(WithI2CBus(bus), WithModel(ModelAuto), WithBoardModelFile(path))
+		require.NoError(t, err)
+		assert.Equal(t, expected, fan.Model(), board)
+		assert.Empty(t, bus.Writes(), "probing must not write to the controller")
+	}
+
+	_, err := Connect(WithI2CBus(NewMemoryI2CBus()), WithModel(ModelAuto), WithBoardModelFile(filepath.Join(dir, "missing")))
+	assert.Error(t, err, "an unknown board must not be guessed")
+}
+
+func TestSignalPowerOff(t *testing.T) {
+	for model, expected := range map[Model][]byte{
+		ArgonOneV2: {bytePowerOff},
+		ArgonOneV3: {registerControl, controlPowerOff},
+	} {
+		bus := NewMemoryI2CBus()
+		fan, err := Connect(WithI2CBus(bus), WithModel(model))
+		require.NoError(t, err)
+		require.NoError(t, fan.SignalPowerOff())
+		assert.Equal(t, [][]byte{expected}, bus.Writes(), model.String())
+	}
+}
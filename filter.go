@@ -0,0 +1,123 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  filter.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// filter smoothes successive readings.
+type filter interface {
+	apply(v float32) float32
+}
+
+// window holds the last n readings.
+type window struct {
+	n      int
+	values []float32
+}
+
+func (w *window) push(v float32) {
+	w.values = append(w.values, v)
+	if len(w.values) > w.n {
+		w.values = w.values[1:]
+	}
+}
+
+type movingAverage struct {
+	window
+}
+
+func (f *movingAverage) apply(v float32) float32 {
+	f.push(v)
+	var sum float32
+	for _, x := range f.values {
+		sum += x
+	}
+	return sum / float32(len(f.values))
+}
+
+type exponentialMovingAverage struct {
+	alpha   float32
+	value   float32
+	started bool
+}
+
+func (f *exponentialMovingAverage) apply(v float32) float32 {
+	if !f.started {
+		f.value, f.started = v, true
+		return v
+	}
+	f.value += f.alpha * (v - f.value)
+	return f.value
+}
+
+type median struct {
+	window
+}
+
+func (f *median) apply(v float32) float32 {
+	f.push(v)
+	sorted := slices.Clone(f.values)
+	slices.Sort(sorted)
+	if l := len(sorted); l%2 == 0 {
+		return (sorted[l/2-1] + sorted[l/2]) / 2
+	}
+	return sorted[len(sorted)/2]
+}
+
+// WithMovingAverage is an option that smoothes the readings
+// by averaging the last n of them.
+func WithMovingAverage(n int) ThermalReaderOption {
+	return func(tr *ThermalReader) error {
+		if n < 1 {
+			return fmt.Errorf("window of moving average must be at least 1: %d", n)
+		}
+		tr.filters = append(tr.filters, &movingAverage{window{n: n}})
+		return nil
+	}
+}
+
+// WithExponentialMovingAverage is an option that smoothes the readings
+// with an exponential moving average. alpha is the weight of the newest
+// reading and must be in (0, 1]: the lower, the smoother.
+func WithExponentialMovingAverage(alpha float32) ThermalReaderOption {
+	return func(tr *ThermalReader) error {
+		if alpha <= 0 || alpha > 1 {
+			return fmt.Errorf("alpha of exponential moving average must be in (0, 1]: %g", alpha)
+		}
+		tr.filters = append(tr.filters, &exponentialMovingAverage{alpha: alpha})
+		return nil
+	}
+}
+
+// WithMedianFilter is an option that rejects outliers by using
+// the median of the last n readings.
+func WithMedianFilter(n int) ThermalReaderOption {
+	return func(tr *ThermalReader) error {
+		if n < 1 {
+			return fmt.Errorf("window of median filter must be at least 1: %d", n)
+		}
+		tr.filters = append(tr.filters, &median{window{n: n}})
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package argononefan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilters(t *testing.T) {
+	readings := []float32{50, 52, 80, 54, 56}
+	testCases := []struct {
+		desc     string
+		filter   filter
+		expected []float32
+	}{
+		{"moving average", &movingAverage{window{n: 3}}, []float32{50, 51, 182.0 / 3, 62, 190.0 / 3}},
+		{"exponential moving average", &exponentialMovingAverage{alpha: 0.5}, []float32{50, 51, 65.5, 59.75, 57.875}},
+		{"median", &median{window{n: 3}}, []float32{50, 51, 52, 54, 56}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			for i, r := range readings {
+				assert.InDelta(t, tC.expected[i], tC.filter.apply(r), 0.001, "reading %d", i)
+			}
+		})
+	}
+}
+
+func TestThermalReaderFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "temp")
+	require.NoError(t, os.WriteFile(path, []byte("50000\n"), 0o644))
+
+	_, err := NewThermalReader(WithThermalDeviceFile(path), WithMovingAverage(0))
+	assert.Error(t, err)
+	_, err = NewThermalReader(WithThermalDeviceFile(path), WithExponentialMovingAverage(1.5))
+	assert.Error(t, err)
+
+	tr, err := NewThermalReader(WithThermalDeviceFile(path), WithMovingAverage(2))
+	require.NoError(t, err)
+
+	c, err := tr.Celsius()
+	require.NoError(t, err)
+	assert.Equal(t, float32(50), c)
+
+	require.NoError(t, os.WriteFile(path, []byte("54000\n"), 0o644))
+	c, err = tr.Celsius()
+	require.NoError(t, err)
+	assert.Equal(t, float32(52), c)
+}
@@ -0,0 +1,214 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  model.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Model is the model of the ArgonOne case.
+// It determines the protocol used to talk to the controller of the case.
+type Model int
+
+const (
+	// ModelAuto determines the model from the Raspberry Pi the fan is
+	// connected to, as the V3 is made for the Pi 5. See WithBoardModelFile.
+	ModelAuto Model = iota
+	// ArgonOneV2 is the original ArgonOne case for the Raspberry Pi 4
+	// and its M.2 variant. The fan speed is written as a bare byte.
+	ArgonOneV2
+	// ArgonOneV3 is the ArgonOne V3 case for the Raspberry Pi 5.
+	// The fan speed is written to a register of the controller
+	// and can be read back.
+	ArgonOneV3
+)
+
+const (
+	// registerFanSpeed is the register of the ArgonOne V3 controller
+	// holding the duty cycle of the fan in percent.
+	registerFanSpeed = 0x80
+	// registerControl is the control register of the ArgonOne V3 controller.
+	registerControl = 0x86
+	// controlPowerOff makes the ArgonOne V3 controller cut power
+	// once the Pi has halted, when written to registerControl.
+	controlPowerOff = 0x01
+	// bytePowerOff makes the controller of the original ArgonOne case
+	// cut power once the Pi has halted. It is written like a fan speed.
+	bytePowerOff = 0xFF
+)
+
+// DefaultBoardModelFile is the file the kernel exposes the model of the board in.
+const DefaultBoardModelFile = "/proc/device-tree/model"
+
+// errReadUnsupported is returned by protocols which can not read
+// the fan speed from the controller.
+var errReadUnsupported = errors.New("reading the fan speed is not supported")
+
+// ParseModel parses the name of a model as returned by Model.String.
+func ParseModel(s string) (Model, error) {
+	switch strings.ToLower(s) {
+	case "auto", "":
+		return ModelAuto, nil
+	case "v2", "argononev2":
+		return ArgonOneV2, nil
+	case "v3", "argononev3":
+		return ArgonOneV3, nil
+	}
+	return ModelAuto, fmt.Errorf("unknown model '%s': must be one of auto, v2 or v3", s)
+}
+
+// String returns the name of the model.
+func (m Model) String() string {
+	switch m {
+	case ModelAuto:
+		return "auto"
+	case ArgonOneV2:
+		return "v2"
+	case ArgonOneV3:
+		return "v3"
+	}
+	return fmt.Sprintf("Model(%d)", int(m))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Model) UnmarshalText(text []byte) error {
+	parsed, err := ParseModel(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m Model) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// WithModel is an option that sets the model of the ArgonOne case.
+// Defaults to ArgonOneV2. With ModelAuto, the model is determined
+// from the model of the board when connecting.
+func WithModel(m Model) FanOption {
+	return func(f *Fan) error {
+		if m < ModelAuto || m > ArgonOneV3 {
+			return fmt.Errorf("unknown model: %s", m)
+		}
+		f.model = m
+		return nil
+	}
+}
+
+// WithBoardModelFile is an option that sets the file the model of the board
+// is read from with ModelAuto. Defaults to DefaultBoardModelFile.
+func WithBoardModelFile(path string) FanOption {
+	return func(f *Fan) error {
+		f.boardModelFile = path
+		return nil
+	}
+}
+
+// protocol is the way a model expects to be talked to.
+type protocol interface {
+	writeSpeed(b I2CBus, speed int) error
+	canReadSpeed() bool
+	readSpeed(b I2CBus) (int, error)
+	signalPowerOff(b I2CBus) error
+}
+
+func protocolFor(m Model) protocol {
+	if m == ArgonOneV3 {
+		return registerProtocol{}
+	}
+	return byteProtocol{}
+}
+
+// byteProtocol writes the fan speed as a single byte.
+type byteProtocol struct{}
+
+func (byteProtocol) writeSpeed(b I2CBus, speed int) error {
+	_, err := b.Write([]byte{byte(speed)})
+	return err
+}
+
+func (byteProtocol) signalPowerOff(b I2CBus) error {
+	_, err := b.Write([]byte{bytePowerOff})
+	return err
+}
+
+func (byteProtocol) canReadSpeed() bool { return false }
+
+func (byteProtocol) readSpeed(I2CBus) (int, error) {
+	return 0, errReadUnsupported
+}
+
+// registerProtocol writes the fan speed to registerFanSpeed.
+type registerProtocol struct{}
+
+func (registerProtocol) writeSpeed(b I2CBus, speed int) error {
+	_, err := b.Write([]byte{registerFanSpeed, byte(speed)})
+	return err
+}
+
+func (registerProtocol) signalPowerOff(b I2CBus) error {
+	_, err := b.Write([]byte{registerControl, controlPowerOff})
+	return err
+}
+
+func (registerProtocol) canReadSpeed() bool { return true }
+
+func (registerProtocol) readSpeed(b I2CBus) (int, error) {
+	v, err := readRegister(b, registerFanSpeed)
+	if err != nil {
+		return 0, err
+	}
+	if v > 100 {
+		return 0, fmt.Errorf("implausible fan speed read from controller: %d", v)
+	}
+	return int(v), nil
+}
+
+func readRegister(b I2CBus, reg byte) (byte, error) {
+	if _, err := b.Write([]byte{reg}); err != nil {
+		return 0, fmt.Errorf("selecting register 0x%02x: %w", reg, err)
+	}
+	buf := make([]byte, 1)
+	if _, err := b.Read(buf); err != nil {
+		return 0, fmt.Errorf("reading register 0x%02x: %w", reg, err)
+	}
+	return buf[0], nil
+}
+
+// probeModel determines the model from the board named in path:
+// the ArgonOne V3 is made for the Raspberry Pi 5, the original case for its predecessors.
+// The controller itself is not probed, as the original one takes any byte
+// written to it as a fan speed.
+func probeModel(path string) (Model, error) {
+	board, err := os.ReadFile(path)
+	if err != nil {
+		return ModelAuto, fmt.Errorf("determining model of the board, set the model of the case instead: %w", err)
+	}
+	if strings.HasPrefix(string(board), "Raspberry Pi 5") {
+		return ArgonOneV3, nil
+	}
+	return ArgonOneV2, nil
+}
@@ -0,0 +1,187 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  multireader.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+// Aggregation determines how a MultiReader combines the readings of its sensors.
+type Aggregation string
+
+const (
+	// AggregateMax uses the highest reading.
+	AggregateMax Aggregation = "max"
+	// AggregateAverage uses the average of the readings, weighted by the weights of the sensors.
+	AggregateAverage Aggregation = "average"
+)
+
+// ErrNoSensors is returned by NewMultiReader if no sensor was added.
+var ErrNoSensors = errors.New("no sensors")
+
+// TemperatureSource is a source of temperature readings.
+// *ThermalReader implements TemperatureSource.
+type TemperatureSource interface {
+	Read(ctx context.Context) (Reading, error)
+}
+
+type sensor struct {
+	name   string
+	source TemperatureSource
+	weight float64
+}
+
+// MultiReaderOption is a function that configures a MultiReader instance.
+type MultiReaderOption func(*MultiReader) error
+
+// MultiReader combines the readings of several sensors,
+// for example multiple thermal zones or the hwmon input of an NVMe drive.
+type MultiReader struct {
+	sensors     []sensor
+	aggregation Aggregation
+
+	mu       sync.Mutex
+	readings map[string]float32
+}
+
+// NewMultiReader creates a new MultiReader instance.
+// At least one sensor must be added using the WithSensor option.
+// The default aggregation is AggregateMax.
+func NewMultiReader(opts ...MultiReaderOption) (*MultiReader, error) {
+	mr := &MultiReader{aggregation: AggregateMax}
+
+	for _, opt := range opts {
+		if err := opt(mr); err != nil {
+			return nil, fmt.Errorf("creating multi reader: %w", err)
+		}
+	}
+
+	if len(mr.sensors) == 0 {
+		return nil, fmt.Errorf("creating multi reader: %w", ErrNoSensors)
+	}
+	return mr, nil
+}
+
+// WithSensor is an option that adds a sensor named name, read from source.
+// weight is only used with AggregateAverage and must be positive.
+func WithSensor(name string, source TemperatureSource, weight float64) MultiReaderOption {
+	return func(mr *MultiReader) error {
+		if weight <= 0 {
+			return fmt.Errorf("weight of sensor '%s' must be positive: %g", name, weight)
+		}
+		for _, s := range mr.sensors {
+			if s.name == name {
+				return fmt.Errorf("duplicate sensor '%s'", name)
+			}
+		}
+		mr.sensors = append(mr.sensors, sensor{name: name, source: source, weight: weight})
+		return nil
+	}
+}
+
+// WithAggregation is an option that sets how the readings of the sensors are combined.
+func WithAggregation(a Aggregation) MultiReaderOption {
+	return func(mr *MultiReader) error {
+		switch a {
+		case AggregateMax, AggregateAverage:
+			mr.aggregation = a
+			return nil
+		}
+		return fmt.Errorf("unknown aggregation '%s'", a)
+	}
+}
+
+// Read reads all sensors and returns the combined temperature.
+// If any sensor fails, an error is returned.
+//
+// The source of the reading is the name of the hottest sensor
+// for AggregateMax, and "average" for AggregateAverage.
+func (mr *MultiReader) Read(ctx context.Context) (Reading, error) {
+	var (
+		readings = make(map[string]float32, len(mr.sensors))
+		errs     []error
+		hottest  string
+		max      = float32(math.Inf(-1))
+		sum      float64
+		weights  float64
+	)
+
+	for _, s := range mr.sensors {
+		r, err := s.source.Read(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sensor '%s': %w", s.name, err))
+			continue
+		}
+		t := r.Temperature.Celsius()
+		readings[s.name] = t
+		if t > max {
+			hottest, max = s.name, t
+		}
+		sum += float64(t) * s.weight
+		weights += s.weight
+	}
+
+	if len(errs) > 0 {
+		return Reading{}, errors.Join(errs...)
+	}
+
+	mr.mu.Lock()
+	mr.readings = readings
+	mr.mu.Unlock()
+
+	if mr.aggregation == AggregateAverage {
+		return Reading{Temperature: FromCelsius(float32(sum / weights)), Time: time.Now(), Source: string(AggregateAverage)}, nil
+	}
+	return Reading{Temperature: FromCelsius(max), Time: time.Now(), Source: hottest}, nil
+}
+
+// Celsius reads all sensors and returns the combined temperature in Celsius.
+// If any sensor fails, an error is returned.
+func (mr *MultiReader) Celsius() (float32, error) {
+	r, err := mr.Read(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature.Celsius(), nil
+}
+
+// Fahrenheit reads all sensors and returns the combined temperature in Fahrenheit.
+func (mr *MultiReader) Fahrenheit() (float32, error) {
+	c, err := mr.Celsius()
+	if err != nil {
+		return 0, fmt.Errorf("obtaining temperature in Celsius: %w", err)
+	}
+	return FromCelsius(c).Fahrenheit(), nil
+}
+
+// Readings returns the readings in Celsius of the individual sensors
+// by name, as of the last successful call to Read.
+func (mr *MultiReader) Readings() map[string]float32 {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	return maps.Clone(mr.readings)
+}
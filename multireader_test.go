@@ -0,0 +1,47 @@
+package argononefan
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedSource struct {
+	t   float32
+	err error
+}
+
+func (s fixedSource) Read(ctx context.Context) (Reading, error) {
+	return Reading{Temperature: FromCelsius(s.t), Time: time.Now(), Source: "fixed"}, s.err
+}
+
+func TestMultiReader(t *testing.T) {
+	_, err := NewMultiReader()
+	assert.ErrorIs(t, err, ErrNoSensors)
+
+	_, err = NewMultiReader(WithSensor("cpu", fixedSource{t: 50}, 1), WithSensor("cpu", fixedSource{t: 50}, 1))
+	assert.Error(t, err, "duplicate sensors")
+
+	mr, err := NewMultiReader(WithSensor("cpu", fixedSource{t: 50}, 1), WithSensor("nvme", fixedSource{t: 62}, 3))
+	require.NoError(t, err)
+	r, err := mr.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, float32(62), r.Temperature.Celsius())
+	assert.Equal(t, "nvme", r.Source)
+	assert.Equal(t, map[string]float32{"cpu": 50, "nvme": 62}, mr.Readings())
+
+	mr, err = NewMultiReader(WithAggregation(AggregateAverage), WithSensor("cpu", fixedSource{t: 50}, 1), WithSensor("nvme", fixedSource{t: 62}, 3))
+	require.NoError(t, err)
+	c, err := mr.Celsius()
+	require.NoError(t, err)
+	assert.Equal(t, float32(59), c)
+
+	mr, err = NewMultiReader(WithSensor("cpu", fixedSource{t: 50}, 1), WithSensor("nvme", fixedSource{err: errors.New("gone")}, 1))
+	require.NoError(t, err)
+	_, err = mr.Celsius()
+	assert.ErrorContains(t, err, "sensor 'nvme': gone")
+}
@@ -22,11 +22,13 @@ make
 %install
 rm -rf $RPM_BUILD_ROOT
 install -D -m 0640 rpm/argononefan.service $RPM_BUILD_ROOT/lib/systemd/system/argononefan.service
+install -D -m 0755 rpm/argononefan-poweroff $RPM_BUILD_ROOT/lib/systemd/system-shutdown/argononefan-poweroff
 install -D -m 640 rpm/sysconfig $RPM_BUILD_ROOT/%{_sysconfdir}/sysconfig/argononefan
 install -D -m 0750 argononefan $RPM_BUILD_ROOT/%{_sbindir}/argononefan
 
 %files
 /lib/systemd/system/argononefan.service
+/lib/systemd/system-shutdown/argononefan-poweroff
 %{_sbindir}/argononefan
 
 %config(noreplace)
@@ -20,10 +20,16 @@
 package argononefan
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // DefaultThermalDeviceFile is the path in sysfs containing current CPU temperature
@@ -32,14 +38,43 @@ const DefaultThermalDeviceFile = "/sys/class/thermal/thermal_zone0/temp"
 const (
 	// The temperature multiplier
 	multiplier = float32(1000)
+
+	// maxFileSize limits how much of a device file is read. Readings are a few bytes.
+	maxFileSize = 64
+)
+
+const (
+	// DefaultMinPlausibleTemperature is the lowest temperature in °C
+	// considered a valid reading by default.
+	DefaultMinPlausibleTemperature = -40
+	// DefaultMaxPlausibleTemperature is the highest temperature in °C
+	// considered a valid reading by default.
+	DefaultMaxPlausibleTemperature = 125
+)
+
+var (
+	// ErrSensorMissing is returned if the device file of a sensor does not exist.
+	ErrSensorMissing = errors.New("sensor missing")
+	// ErrParse is returned if the content of a device file is not a temperature.
+	ErrParse = errors.New("not a temperature")
+	// ErrImplausible is returned if a temperature is outside of the plausible range.
+	ErrImplausible = errors.New("temperature out of plausible range")
 )
 
 // ThermalReaderOption is a function that configures a ThermalReader instance.
 type ThermalReaderOption func(*ThermalReader) error
 
 // ThermalReader is a type that represents a thermal reader to read the CPU temperature.
+//
+// Filters set using WithMovingAverage, WithExponentialMovingAverage or
+// WithMedianFilter are applied to the readings in the order they were set.
 type ThermalReader struct {
 	filepath string
+	unit     Unit
+	min, max float32
+
+	mu      sync.Mutex
+	filters []filter
 }
 
 // NewThermalReader creates a new ThermalReader instance.
@@ -48,6 +83,9 @@ type ThermalReader struct {
 func NewThermalReader(opts ...ThermalReaderOption) (*ThermalReader, error) {
 	tr := &ThermalReader{
 		filepath: DefaultThermalDeviceFile,
+		unit:     UnitMilliCelsius,
+		min:      DefaultMinPlausibleTemperature,
+		max:      DefaultMaxPlausibleTemperature,
 	}
 
 	for _, opt := range opts {
@@ -67,29 +105,110 @@ func WithThermalDeviceFile(filepath string) ThermalReaderOption {
 		tr.filepath = filepath
 		info, err := os.Stat(tr.filepath)
 		if os.IsNotExist(err) {
-			return fmt.Errorf("file '%s' does not exist: %w", tr.filepath, err)
+			return fmt.Errorf("file '%s' does not exist: %w: %w", tr.filepath, ErrSensorMissing, err)
 		} else if os.IsPermission(err) {
 			return fmt.Errorf("file '%s' is not accessible: %w", tr.filepath, err)
+		} else if err != nil {
+			return fmt.Errorf("file '%s' can not be examined: %w", tr.filepath, err)
 		} else if info.IsDir() {
-			return fmt.Errorf("file '%s' is a directory: %w", tr.filepath, err)
+			return fmt.Errorf("file '%s' is a directory", tr.filepath)
 		}
 		return nil
 	}
 }
 
-// Celsius returns the current CPU temperature in Celsius.
-func (tr *ThermalReader) Celsius() (float32, error) {
+// WithUnit is an option that sets the unit of the readings in the device file.
+// The default is UnitMilliCelsius, used by thermal zones and hwmon inputs.
+// Use UnitCelsius for files containing plain degrees, like 48.5.
+func WithUnit(u Unit) ThermalReaderOption {
+	return func(tr *ThermalReader) error {
+		switch u {
+		case UnitMilliCelsius, UnitCelsius:
+			tr.unit = u
+			return nil
+		}
+		return fmt.Errorf("unknown unit '%s'", u)
+	}
+}
+
+// WithPlausibleRange is an option that sets the range of temperatures in °C
+// considered valid readings. Readings outside of it fail with ErrImplausible.
+// The default is DefaultMinPlausibleTemperature to DefaultMaxPlausibleTemperature.
+func WithPlausibleRange(min, max float32) ThermalReaderOption {
+	return func(tr *ThermalReader) error {
+		if min >= max {
+			return fmt.Errorf("minimum of plausible range must be below maximum: %g, %g", min, max)
+		}
+		tr.min, tr.max = min, max
+		return nil
+	}
+}
+
+// Read reads the current temperature.
+// If ctx is done before the device file could be read, ctx.Err() is returned.
+func (tr *ThermalReader) Read(ctx context.Context) (Reading, error) {
+	if err := ctx.Err(); err != nil {
+		return Reading{}, err
+	}
+
+	type result struct {
+		c   float32
+		err error
+	}
+	// Buffered, so that the goroutine of a stuck read can still exit
+	// once the read returns, after Read gave up on it.
+	resC := make(chan result, 1)
+	go func() {
+		c, err := tr.readFile()
+		resC <- result{c, err}
+	}()
+
+	var res result
+	select {
+	case <-ctx.Done():
+		return Reading{}, ctx.Err()
+	case res = <-resC:
+	}
+	if res.err != nil {
+		return Reading{}, res.err
+	}
+
+	c := res.c
+	if c < tr.min || c > tr.max {
+		return Reading{}, fmt.Errorf("%2.1f°C: %w", c, ErrImplausible)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, f := range tr.filters {
+		c = f.apply(c)
+	}
+	return Reading{Temperature: FromCelsius(c), Time: time.Now(), Source: tr.filepath}, nil
+}
+
+func (tr *ThermalReader) readFile() (float32, error) {
 	in, err := os.OpenFile(tr.filepath, os.O_RDONLY, 0)
-	if err != nil {
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("opening temperature file: %w: %w", ErrSensorMissing, err)
+	} else if err != nil {
 		return 0, fmt.Errorf("opening temperature file: %w", err)
 	}
 	defer in.Close()
 
-	t, err := readCPUTemperature(in)
+	c, err := readCPUTemperature(in, tr.unit)
 	if err != nil {
 		return 0, fmt.Errorf("reading temperature: %w", err)
 	}
-	return float32(t) / multiplier, nil
+	return c, nil
+}
+
+// Celsius returns the current CPU temperature in Celsius.
+func (tr *ThermalReader) Celsius() (float32, error) {
+	r, err := tr.Read(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature.Celsius(), nil
 }
 
 // Fahrenheit returns the current CPU temperature in Fahrenheit.
@@ -98,17 +217,39 @@ func (tr *ThermalReader) Fahrenheit() (float32, error) {
 	if err != nil {
 		return 0, fmt.Errorf("obtaining temperature in Celsius: %w", err)
 	}
-	return (c * 9 / 5) + 32, nil
+	return FromCelsius(c).Fahrenheit(), nil
 }
 
-func readCPUTemperature(in io.Reader) (int, error) {
-	b, err := io.ReadAll(in)
+// readCPUTemperature reads a temperature in unit from in and returns it in °C.
+// Surrounding whitespace is ignored.
+func readCPUTemperature(in io.Reader, unit Unit) (float32, error) {
+	b, err := io.ReadAll(io.LimitReader(in, maxFileSize+1))
 	if err != nil {
 		return 0, fmt.Errorf("reading temperature: %w", err)
 	}
-	t, err := strconv.Atoi(string(b[:len(b)-1]))
+	if len(b) > maxFileSize {
+		return 0, fmt.Errorf("parsing temperature: %w: more than %d bytes", ErrParse, maxFileSize)
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, fmt.Errorf("parsing temperature: %w: empty", ErrParse)
+	}
+
+	var t float64
+	if unit == UnitMilliCelsius {
+		// Millidegrees are always integers.
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		t = float64(i) / float64(multiplier)
+	} else {
+		t, err = strconv.ParseFloat(s, 32)
+		if err == nil && (math.IsNaN(t) || math.IsInf(t, 0)) {
+			err = fmt.Errorf("invalid value %q", s)
+		}
+	}
 	if err != nil {
-		return 0, fmt.Errorf("parsing temperature: %w", err)
+		return 0, fmt.Errorf("parsing temperature: %w: %w", ErrParse, err)
 	}
-	return t, nil
+	return float32(t), nil
 }
@@ -0,0 +1,42 @@
+package argononefan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCPUTemperature(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		in       string
+		unit     Unit
+		expected float32
+		err      error
+	}{
+		{"thermal zone", "48500\n", UnitMilliCelsius, 48.5, nil},
+		{"no trailing newline", "48500", UnitMilliCelsius, 48.5, nil},
+		{"whitespace", "  48500 \r\n", UnitMilliCelsius, 48.5, nil},
+		{"negative", "-5250\n", UnitMilliCelsius, -5.25, nil},
+		{"plain degrees", "48.5\n", UnitCelsius, 48.5, nil},
+		{"plain integer degrees", "48\n", UnitCelsius, 48, nil},
+		{"empty", "", UnitMilliCelsius, 0, ErrParse},
+		{"only newline", "\n", UnitMilliCelsius, 0, ErrParse},
+		{"garbage", "hot\n", UnitMilliCelsius, 0, ErrParse},
+		{"decimals in millidegrees", "48.5\n", UnitMilliCelsius, 0, ErrParse},
+		{"not a number", "NaN\n", UnitCelsius, 0, ErrParse},
+		{"too long", strings.Repeat("1", 100), UnitMilliCelsius, 0, ErrParse},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c, err := readCPUTemperature(strings.NewReader(tC.in), tC.unit)
+			if tC.err != nil {
+				assert.ErrorIs(t, err, tC.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expected, c)
+		})
+	}
+}
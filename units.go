@@ -0,0 +1,108 @@
+/*
+ *  Copyright 2024 Markus W Mahlberg
+ *
+ *  units.go is part of argononefan
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package argononefan
+
+import (
+	"fmt"
+	"time"
+)
+
+// absoluteZero is 0 K in °C.
+const absoluteZero = -273.15
+
+// Scale is a temperature scale.
+type Scale string
+
+const (
+	ScaleCelsius    Scale = "°C"
+	ScaleFahrenheit Scale = "°F"
+	ScaleKelvin     Scale = "K"
+)
+
+// Temperature is a temperature, independent of any scale.
+// Use FromCelsius, FromFahrenheit or FromKelvin to create one.
+// The zero value is 0°C.
+type Temperature struct {
+	celsius float32
+}
+
+// FromCelsius returns the Temperature of c °C.
+func FromCelsius(c float32) Temperature {
+	return Temperature{celsius: c}
+}
+
+// FromFahrenheit returns the Temperature of f °F.
+func FromFahrenheit(f float32) Temperature {
+	return Temperature{celsius: (f - 32) * 5 / 9}
+}
+
+// FromKelvin returns the Temperature of k K.
+func FromKelvin(k float32) Temperature {
+	return Temperature{celsius: k + absoluteZero}
+}
+
+// Celsius returns the temperature in °C.
+func (t Temperature) Celsius() float32 {
+	return t.celsius
+}
+
+// Fahrenheit returns the temperature in °F.
+func (t Temperature) Fahrenheit() float32 {
+	return t.celsius*9/5 + 32
+}
+
+// Kelvin returns the temperature in K.
+func (t Temperature) Kelvin() float32 {
+	return t.celsius - absoluteZero
+}
+
+// In returns the temperature in scale s. Unknown scales default to Celsius.
+func (t Temperature) In(s Scale) float32 {
+	switch s {
+	case ScaleFahrenheit:
+		return t.Fahrenheit()
+	case ScaleKelvin:
+		return t.Kelvin()
+	}
+	return t.Celsius()
+}
+
+// StringIn formats the temperature in scale s with one decimal, like 48.5°C.
+func (t Temperature) StringIn(s Scale) string {
+	if s != ScaleFahrenheit && s != ScaleKelvin {
+		s = ScaleCelsius
+	}
+	return fmt.Sprintf("%.1f%s", t.In(s), s)
+}
+
+// String formats the temperature in Celsius, like 48.5°C.
+func (t Temperature) String() string {
+	return t.StringIn(ScaleCelsius)
+}
+
+// Reading is a temperature read from a sensor.
+type Reading struct {
+	Temperature Temperature
+	// Time the temperature was read at.
+	Time time.Time
+	// Source is the sensor the temperature was read from,
+	// the path of the device file for a ThermalReader.
+	Source string
+}
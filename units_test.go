@@ -0,0 +1,64 @@
+package argononefan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemperature(t *testing.T) {
+	temp := FromCelsius(50)
+	assert.Equal(t, float32(122), temp.Fahrenheit())
+	assert.InDelta(t, 323.15, temp.Kelvin(), 0.001)
+	assert.InDelta(t, 50, FromFahrenheit(122).Celsius(), 0.001)
+	assert.InDelta(t, 50, FromKelvin(323.15).Celsius(), 0.001)
+
+	assert.Equal(t, "50.0°C", temp.String())
+	assert.Equal(t, "122.0°F", temp.StringIn(ScaleFahrenheit))
+	assert.Equal(t, "323.1K", temp.StringIn(ScaleKelvin))
+}
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "temp")
+	require.NoError(t, os.WriteFile(path, []byte("48500\n"), 0o644))
+
+	tr, err := NewThermalReader(WithThermalDeviceFile(path))
+	require.NoError(t, err)
+
+	r, err := tr.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, FromCelsius(48.5), r.Temperature)
+	assert.Equal(t, path, r.Source)
+	assert.False(t, r.Time.IsZero())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tr.Read(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, os.WriteFile(path, []byte("ab\n"), 0o644))
+	_, err = tr.Read(context.Background())
+	assert.ErrorIs(t, err, ErrParse)
+
+	require.NoError(t, os.WriteFile(path, []byte("200000\n"), 0o644))
+	_, err = tr.Read(context.Background())
+	assert.ErrorIs(t, err, ErrImplausible)
+
+	require.NoError(t, os.Remove(path))
+	_, err = tr.Read(context.Background())
+	assert.ErrorIs(t, err, ErrSensorMissing)
+	_, err = NewThermalReader(WithThermalDeviceFile(path))
+	assert.ErrorIs(t, err, ErrSensorMissing)
+
+	// A file used as directory fails with ENOTDIR.
+	notDir := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(notDir, []byte("42000\n"), 0o644))
+	_, err = NewThermalReader(WithThermalDeviceFile(filepath.Join(notDir, "temp")))
+	assert.Error(t, err)
+	_, err = NewThermalReader(WithThermalDeviceFile(t.TempDir()))
+	assert.ErrorContains(t, err, "is a directory")
+}